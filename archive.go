@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jguillaumes/xmit_reader/internal/unloadfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitfile"
+)
+
+var (
+	zipMagic  = []byte{'P', 'K', 0x03, 0x04}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// inputEntry is a XMIT file or IEBCOPY unload to be processed. A zip archive
+// can hold several of them.
+type inputEntry struct {
+	name string // Name of the zip entry, empty if the input is not a zip archive
+	open func() (io.ReadCloser, error)
+}
+
+// dir returns the directory where the contents of a zip entry are written,
+// relative to the target directory: the entry name without its extension
+func (e inputEntry) dir() string {
+	if e.name == "" {
+		return ""
+	}
+	return filepath.FromSlash(strings.TrimSuffix(e.name, path.Ext(e.name)))
+}
+
+// openInput opens the input file and returns the XMIT files it holds. A zip
+// archive returns every entry holding a XMIT file or an IEBCOPY unload, or
+// only the entries matching pattern if it is not empty. A gzip file is
+// decompressed while it is read. Any other file is returned as is. The
+// returned closer closes the input file.
+func openInput(name string, pattern string) ([]inputEntry, io.Closer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(f, magic)
+	magic = magic[:n]
+
+	rewind := func() error {
+		_, err := f.Seek(0, io.SeekStart)
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		entries, err := zipEntries(f, pattern)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return entries, f, nil
+	case bytes.HasPrefix(magic, gzipMagic):
+		log.Debugf("Input file %s is gzip compressed\n", name)
+		open := func() (io.ReadCloser, error) {
+			if err := rewind(); err != nil {
+				return nil, err
+			}
+			return gzip.NewReader(f)
+		}
+		return []inputEntry{{open: open}}, f, nil
+	default:
+		open := func() (io.ReadCloser, error) {
+			if err := rewind(); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(f), nil
+		}
+		return []inputEntry{{open: open}}, f, nil
+	}
+}
+
+// zipEntries returns the entries of a zip archive holding a XMIT file or an
+// IEBCOPY unload, and matching pattern if it is not empty
+func zipEntries(f *os.File, pattern string) ([]inputEntry, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]inputEntry, 0)
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		if pattern != "" && !matchEntry(pattern, zf.Name) {
+			continue
+		}
+		if !filepath.IsLocal(zf.Name) {
+			log.Warnf("Ignoring archive entry %s: unsafe name\n", zf.Name)
+			continue
+		}
+		isXmit, err := isXMITEntry(zf)
+		if err != nil {
+			return nil, fmt.Errorf("entry %s: %w", zf.Name, err)
+		}
+		if !isXmit {
+			if pattern != "" {
+				log.Warnf("Archive entry %s is not a XMIT file or an IEBCOPY unload\n", zf.Name)
+			} else {
+				log.Debugf("Ignoring archive entry %s, it is not a XMIT file\n", zf.Name)
+			}
+			continue
+		}
+		entries = append(entries, inputEntry{name: zf.Name, open: zf.Open})
+	}
+	if len(entries) == 0 {
+		if pattern != "" {
+			return nil, fmt.Errorf("no XMIT file matching %s found in the archive", pattern)
+		}
+		return nil, fmt.Errorf("no XMIT file found in the archive")
+	}
+	return entries, nil
+}
+
+// isXMITEntry tells if a zip entry starts like a XMIT file or an IEBCOPY unload
+func isXMITEntry(zf *zip.File) (bool, error) {
+	r, err := zf.Open()
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	head := make([]byte, 16)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	return isInputFile(head[:n]), nil
+}
+
+// isInputFile tells if head, the start of a file, belongs to a XMIT file or
+// an IEBCOPY unload
+func isInputFile(head []byte) bool {
+	return xmitfile.IsXMIT(head) || unloadfile.IsUnload(head)
+}
+
+// matchEntry tells if the name of a zip entry, or its last element, matches
+// pattern. The comparison is not case sensitive.
+func matchEntry(pattern string, name string) bool {
+	pattern = strings.ToUpper(pattern)
+	name = strings.ToUpper(name)
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+	ok, _ := path.Match(pattern, path.Base(name))
+	return ok
+}
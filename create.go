@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jguillaumes/xmit_reader/internal/unloadfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitutils"
+)
+
+var memberNameRegex = regexp.MustCompile(`^[A-Z@#$][A-Z0-9@#$]{0,7}$`)
+
+// createMain implements the create mode: it builds a XMIT file holding a PDS
+// whose members are the files of a local directory
+func createMain(args []string) int {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	sourceDir := fs.String("source", "", "Directory holding the files to be transmitted as PDS members")
+	outputFile := fs.String("output", "", "Output XMIT file")
+	dsName := fs.String("dsname", "", "Name of the transmitted dataset")
+	typeExt := fs.String("type", "", "Only include files with this type (extension). The default is to include all files")
+	recfm := fs.String("recfm", "FB", "Record format of the PDS (F, FB, V or VB)")
+	lrecl := fs.Int("lrecl", 80, "Logical record length of the PDS")
+	blksize := fs.Int("blksize", 27920, "Block size of the PDS")
+	dirBlocks := fs.Int("dir", 0, "Number of directory blocks. The default is the minimum needed")
+	node := fs.String("node", "LOCAL", "Origin node name")
+	userId := fs.String("user", "", "Origin user ID. The default is the current user")
+	targetNode := fs.String("tnode", "", "Target node name. The default is the origin node")
+	targetUser := fs.String("tuser", "", "Target user ID. The default is the origin user")
+	encoding := fs.String("encoding", "IBM-1047", "EBCDIC encoding used in the generated files. The default is IBM-1047")
+	debugFlag := fs.Bool("debug", false, "Output debug information (maybe quite verbose)")
+
+	fs.Parse(args)
+
+	if *debugFlag {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	if *sourceDir == "" || *outputFile == "" || *dsName == "" {
+		fs.Usage()
+		return 16
+	}
+
+	if *lrecl <= 0 || *blksize <= 0 || *blksize > 32760 {
+		log.Errorf("Invalid LRECL=%d or BLKSIZE=%d\n", *lrecl, *blksize)
+		return 16
+	}
+	*recfm = strings.ToUpper(*recfm)
+	switch *recfm {
+	case "F", "FB":
+		if *blksize%*lrecl != 0 {
+			log.Errorf("BLKSIZE=%d is not a multiple of LRECL=%d\n", *blksize, *lrecl)
+			return 16
+		}
+	case "V", "VB":
+		if *blksize < *lrecl+4 {
+			log.Errorf("BLKSIZE=%d is too small for LRECL=%d\n", *blksize, *lrecl)
+			return 16
+		}
+	default:
+		log.Errorf("Unsupported RECFM=%s\n", *recfm)
+		return 16
+	}
+
+	if *userId == "" {
+		if u, err := user.Current(); err == nil {
+			*userId = u.Username
+		}
+	}
+	*userId = strings.ToUpper(*userId)
+	if len(*userId) > 8 {
+		*userId = (*userId)[:8]
+	}
+	if *targetNode == "" {
+		*targetNode = *node
+	}
+	if *targetUser == "" {
+		*targetUser = *userId
+	}
+
+	params := unloadfile.UnloadParams{
+		Recfm:     *recfm,
+		Lrecl:     uint16(*lrecl),
+		Blksize:   uint16(*blksize),
+		DirBlocks: *dirBlocks,
+		Encoding:  *encoding,
+	}
+	members, size, err := readMembers(*sourceDir, *typeExt, params)
+	if err != nil {
+		log.Errorln(err)
+		return 8
+	}
+
+	// Build the unload in memory, it is needed before writing the INMR02 records
+	unloadRecords := make([][]byte, 0)
+	usedDirBlocks, err := unloadfile.CreateUnload(members, params, func(r []byte) error {
+		unloadRecords = append(unloadRecords, r)
+		return nil
+	})
+	if err != nil {
+		log.Errorln(err)
+		return 8
+	}
+
+	xmitParms := xmitfile.NewXmitParams()
+	xmitParms.SourceNodeName = *node
+	xmitParms.SourceUserId = *userId
+	xmitParms.TargetNodeName = *targetNode
+	xmitParms.TargetUserId = *targetUser
+	xmitParms.SourceTstamp = time.Now()
+	xmitParms.NumFiles = 1
+	xmitParms.XmitFiles = append(xmitParms.XmitFiles,
+		xmitfile.XmitFileParams{
+			FileNumber:    1,
+			SourceDSName:  strings.ToUpper(*dsName),
+			SourceDsorg:   "PO",
+			SourceDstype:  "PDS",
+			SourceRecfm:   *recfm,
+			SourceLrecl:   int16(*lrecl),
+			SourceBlksize: int16(*blksize),
+			AproxSize:     size,
+			DirBlocks:     usedDirBlocks,
+			UtilPgmName:   "IEBCOPY",
+		},
+		xmitfile.XmitFileParams{
+			FileNumber:    1,
+			SourceDsorg:   "PS",
+			SourceRecfmHw: 0x4802, // VS, records without RDW
+			SourceLrecl:   32756,
+			SourceBlksize: 3120,
+			AproxSize:     size,
+			UtilPgmName:   "INMCOPY",
+		})
+
+	out, err := os.Create(*outputFile)
+	if err != nil {
+		log.Error("Error creating output file:", err.Error())
+		return 8
+	}
+	defer out.Close()
+	bw := bufio.NewWriter(out)
+
+	err = xmitfile.WriteXMITFile(bw, xmitParms, func(fileNumber int, writeRecord func([]byte) error) error {
+		for _, r := range unloadRecords {
+			if err := writeRecord(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, *encoding)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		log.Error("Error writing output file:", err.Error())
+		return 8
+	}
+	log.Infof("%d members written to XMIT file %s\n", len(members), *outputFile)
+	return 0
+}
+
+// readMembers reads the files of sourceDir and converts them to EBCDIC records.
+// Each file becomes a member named after the file name without its extension.
+// It also returns the total size of the records.
+func readMembers(sourceDir string, typeExt string, params unloadfile.UnloadParams) ([]unloadfile.UnloadMember, int64, error) {
+	entries, err := os.ReadDir(sourceDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	variable := params.Recfm[0] == 'V'
+	maxLen := int(params.Lrecl)
+	if variable {
+		maxLen -= 4
+	}
+	encoder := xmitutils.SharedEncoding
+	blank, err := encoder.EncodeString(" ", params.Encoding)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var size int64
+	members := make([]unloadfile.UnloadMember, 0, len(entries))
+	names := make(map[string]string)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		if typeExt != "" && !strings.EqualFold(strings.TrimPrefix(ext, "."), typeExt) {
+			continue
+		}
+		name := strings.ToUpper(strings.TrimSuffix(entry.Name(), ext))
+		if !memberNameRegex.MatchString(name) {
+			return nil, 0, fmt.Errorf("file %s: %s is not a valid member name", entry.Name(), name)
+		}
+		if other, ok := names[name]; ok {
+			return nil, 0, fmt.Errorf("files %s and %s would be the same member %s", other, entry.Name(), name)
+		}
+		names[name] = entry.Name()
+
+		f, err := os.Open(filepath.Join(sourceDir, entry.Name()))
+		if err != nil {
+			return nil, 0, err
+		}
+		records := make([][]byte, 0)
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), "\r")
+			record, err := encoder.EncodeString(line, params.Encoding)
+			if err != nil {
+				f.Close()
+				return nil, 0, err
+			}
+			if len(record) > maxLen {
+				log.Warnf("%s: line %d truncated to %d bytes\n", entry.Name(), len(records)+1, maxLen)
+				record = record[:maxLen]
+			}
+			if variable {
+				if len(record) == 0 {
+					record = blank
+				}
+			} else {
+				record = append(record, bytes.Repeat(blank, maxLen-len(record))...)
+			}
+			size += int64(len(record))
+			records = append(records, record)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, 0, fmt.Errorf("error reading %s: %w", entry.Name(), err)
+		}
+		log.Debugf("Member %s: %d records from %s\n", name, len(records), entry.Name())
+		members = append(members, unloadfile.UnloadMember{Name: name, Records: records})
+	}
+	return members, size, nil
+}
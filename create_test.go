@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// roundTripMembers are the files transmitted by the round trip tests. EMPTY
+// becomes a member without records, whose TTR points at its end-of-member
+// marker.
+var roundTripMembers = map[string]string{
+	"ALPHA": "first line\nsecond line\n\nlast line after an empty one\n",
+	"BETA":  strings.Repeat("a block worth of lines, to fill several blocks\n", 400),
+	"EMPTY": "",
+}
+
+// TestCreateRoundTrip creates a XMIT file from a directory and extracts it
+// again, checking that every member comes back with the same lines
+func TestCreateRoundTrip(t *testing.T) {
+	log.SetOutput(io.Discard)
+	for _, tc := range []struct {
+		recfm, lrecl, blksize string
+	}{
+		{"FB", "80", "3120"},
+		{"VB", "255", "3120"},
+	} {
+		t.Run(tc.recfm, func(t *testing.T) {
+			sourceDir := t.TempDir()
+			for name, text := range roundTripMembers {
+				if err := os.WriteFile(filepath.Join(sourceDir, strings.ToLower(name)+".txt"), []byte(text), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			xmitFile := filepath.Join(t.TempDir(), "test.xmit")
+			rc := createMain([]string{"-source", sourceDir, "-output", xmitFile, "-dsname", "USER.TEST.PDS",
+				"-recfm", tc.recfm, "-lrecl", tc.lrecl, "-blksize", tc.blksize, "-user", "TESTER"})
+			if rc != 0 {
+				t.Fatalf("create returned %d", rc)
+			}
+
+			in, err := os.Open(xmitFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer in.Close()
+			targetDir := t.TempDir()
+			n, err := extractXMIT(in, extractOptions{targetDir: targetDir, typeExt: "txt", encoding: "IBM-1047", jobs: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(roundTripMembers) {
+				t.Errorf("%d members extracted, want %d", n, len(roundTripMembers))
+			}
+
+			for name, text := range roundTripMembers {
+				got, err := os.ReadFile(filepath.Join(targetDir, name+".txt"))
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				if want := expectedText(text, tc.recfm); string(got) != want {
+					t.Errorf("member %s: got %q, want %q", name, shorten(string(got)), shorten(want))
+				}
+			}
+		})
+	}
+}
+
+// TestCreateBadDCB checks that invalid DCB attributes are rejected with
+// return code 16, before anything is written
+func TestCreateBadDCB(t *testing.T) {
+	log.SetOutput(io.Discard)
+	for _, tc := range []struct {
+		name                  string
+		recfm, lrecl, blksize string
+	}{
+		{"zero LRECL", "FB", "0", "3120"},
+		{"negative LRECL", "VB", "-4", "3120"},
+		{"zero BLKSIZE", "FB", "80", "0"},
+		{"BLKSIZE too large", "FB", "80", "32800"},
+		{"BLKSIZE not a multiple of LRECL", "FB", "80", "3000"},
+		{"BLKSIZE too small for LRECL", "VB", "255", "255"},
+		{"unsupported RECFM", "U", "0", "6144"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			xmitFile := filepath.Join(t.TempDir(), "test.xmit")
+			rc := createMain([]string{"-source", t.TempDir(), "-output", xmitFile, "-dsname", "USER.TEST.PDS",
+				"-recfm", tc.recfm, "-lrecl", tc.lrecl, "-blksize", tc.blksize})
+			if rc != 16 {
+				t.Errorf("create returned %d, want 16", rc)
+			}
+			if _, err := os.Stat(xmitFile); err == nil {
+				t.Error("output file written")
+			}
+		})
+	}
+}
+
+// expectedText returns the text extracted from a member created from text.
+// Fixed length records are padded with blanks up to the LRECL, and empty
+// lines become a single blank in variable length records.
+func expectedText(text string, recfm string) string {
+	if text == "" {
+		return ""
+	}
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if recfm[0] == 'F' {
+			line = line + strings.Repeat(" ", 80-len(line))
+		} else if line == "" {
+			line = " "
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func shorten(s string) string {
+	if len(s) > 120 {
+		return s[:120] + "..."
+	}
+	return s
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jguillaumes/xmit_reader/internal/xmitfile"
+)
+
+// inspectedEntry is the inspection of a XMIT file found in the input file
+type inspectedEntry struct {
+	Entry string `json:"entry,omitempty"`
+	*xmitfile.Inspection
+}
+
+// inspectMain implements the inspect mode: it shows the control records of
+// the XMIT file, with their text units, and the number of data segments of
+// every transmitted file. The data is not decoded and nothing is written to
+// disk.
+func inspectMain(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	inputFile := fs.String("input", "", "Input XMIT file to be inspected, optionally in a zip or gzip archive")
+	entryFlag := fs.String("entry", "", "Name or pattern of the entries to be inspected when the input is a zip archive. The default is every XMIT file in the archive")
+	jsonFlag := fs.Bool("json", false, "Write the description in JSON format")
+	encoding := fs.String("encoding", "IBM-1047", "EBCDIC encoding used in the control records. The default is IBM-1047")
+	debugFlag := fs.Bool("debug", false, "Output debug information (maybe quite verbose)")
+
+	fs.Parse(args)
+
+	if *debugFlag {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	if *inputFile == "" {
+		fs.Usage()
+		return 16
+	}
+
+	entries, inFile, err := openInput(*inputFile, *entryFlag)
+	if err != nil {
+		log.Error("Error opening input file: ", err.Error())
+		return 8
+	}
+	defer inFile.Close()
+
+	rc := 0
+	inspected := make([]inspectedEntry, 0, len(entries))
+	for _, entry := range entries {
+		inspection, err := inspectEntry(entry, *encoding)
+		if err != nil {
+			if entry.name != "" {
+				log.Errorf("Archive entry %s: %v\n", entry.name, err)
+			} else {
+				log.Errorln(err)
+			}
+			rc = 8
+			continue
+		}
+		inspected = append(inspected, inspectedEntry{Entry: entry.name, Inspection: inspection})
+	}
+
+	if *jsonFlag {
+		marshalled, err := json.MarshalIndent(inspected, "", "  ")
+		if err != nil {
+			log.Errorln(err)
+			return 8
+		}
+		fmt.Println(string(marshalled))
+	} else {
+		out := bufio.NewWriter(os.Stdout)
+		printInspection(out, inspected)
+		out.Flush()
+	}
+	return rc
+}
+
+// inspectEntry describes a XMIT file found in the input file
+func inspectEntry(entry inputEntry, encoding string) (*xmitfile.Inspection, error) {
+	in, err := entry.open()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return xmitfile.InspectXMITFile(in, encoding)
+}
+
+// printInspection writes the control records, their text units and the data
+// segment counts in a readable form
+func printInspection(out io.Writer, inspected []inspectedEntry) {
+	for _, e := range inspected {
+		if e.Entry != "" {
+			fmt.Fprintf(out, "Archive entry %s\n", e.Entry)
+		}
+		fmt.Fprintf(out, "%d control segments, %d data segments\n", e.ControlSegments, e.DataSegments)
+		fmt.Fprintln(out, "Offset    Len  Record  Flags")
+		for _, r := range e.Records {
+			fmt.Fprintf(out, "%08X  %3d  %-6s  %s", r.Offset, r.Length, r.Id, strings.Join(r.Flags, ","))
+			if r.FileNumber != 0 {
+				fmt.Fprintf(out, "  (file %d)", r.FileNumber)
+			}
+			fmt.Fprintln(out)
+			for _, tu := range r.TextUnits {
+				fmt.Fprintf(out, "          %-8s  %04X  %s\n", tu.Name, tu.Key, textUnitValues(tu))
+			}
+			if r.Data != nil {
+				fmt.Fprintf(out, "          Data: %d segments, %d records, %d bytes\n", r.Data.Segments, r.Data.Records, r.Data.Bytes)
+			}
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+// textUnitValues formats the values of a text unit. Character values are
+// quoted, numeric values are followed by their hexadecimal value.
+func textUnitValues(tu xmitfile.InspectedTextUnit) string {
+	if len(tu.Values) == 0 {
+		return "(no value)"
+	}
+	numeric := xmitfile.XmitTextUnitId(tu.Key).IsNumeric()
+	values := make([]string, len(tu.Values))
+	for i, v := range tu.Values {
+		if numeric {
+			values[i] = fmt.Sprintf("%s (X'%s')", v, tu.Hex[i])
+		} else {
+			values[i] = fmt.Sprintf("'%s'", v)
+		}
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,194 @@
+package seqfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jguillaumes/xmit_reader/internal/unloadfile"
+	xmit "github.com/jguillaumes/xmit_reader/internal/xmitfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitutils"
+)
+
+var enc = xmitutils.SharedEncoding
+
+// ProcessSequentialFile converts the records of a transmitted sequential (DSORG=PS)
+// dataset into a single text file. The input file has the same layout as the
+// unload file generated by ProcessXMITFile: each logical record is prefixed
+// by an 8 byte header whose first halfword is the record length, header included.
+// A dataset holding a XMIT file or an IEBCOPY unload is unpacked by nested,
+// or written as a binary file if it is nil. It returns the description of
+// the file written.
+func ProcessSequentialFile(inFile io.Reader, targetDir string, typeExt string, xmf xmit.XmitFileParams, encoding string, nested unloadfile.NestedFunc) (*unloadfile.ExtractedFile, error) {
+	in := bufio.NewReaderSize(inFile, 64*1024)
+	if kind := detectPayload(in); kind != unloadfile.PayloadRecords {
+		return processNestedFile(in, kind, targetDir, xmf, nested)
+	}
+
+	fileName := filepath.Join(targetDir, sequentialFileName(xmf)+"."+strings.Trim(typeExt, " "))
+
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		log.Errorf("cannot create file %s: %v\n", fileName, err)
+		return nil, err
+	}
+	defer outFile.Close()
+
+	log.Infof("Writing file %s\n", fileName)
+
+	digest := unloadfile.NewOutputDigest()
+	out := bufio.NewWriter(io.MultiWriter(outFile, digest))
+	numRecords, err := ReadRecords(in, func(record []byte) error {
+		line, _ := enc.DecodeBytes(record, encoding)
+		out.WriteString(line)
+		return out.WriteByte('\n')
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := out.Flush(); err != nil {
+		return nil, err
+	}
+	log.Debugf("%d records written to %s\n", numRecords, fileName)
+
+	file := &unloadfile.ExtractedFile{
+		Name:    sequentialFileName(xmf),
+		Path:    fileName,
+		Records: numRecords,
+	}
+	digest.Fill(file)
+	return file, outFile.Close()
+}
+
+// detectPayload tells what the dataset holds from its first record, which
+// is left unread in the input
+func detectPayload(in *bufio.Reader) unloadfile.PayloadKind {
+	header, err := in.Peek(8)
+	if err != nil {
+		return unloadfile.PayloadRecords
+	}
+	recLen := int(binary.BigEndian.Uint16(header[0:2]))
+	if recLen < 8 {
+		return unloadfile.PayloadRecords
+	}
+	record, err := in.Peek(recLen)
+	if err != nil {
+		return unloadfile.PayloadRecords
+	}
+	return unloadfile.DetectPayload(record[8:])
+}
+
+// processNestedFile unpacks the XMIT file or IEBCOPY unload held by a
+// sequential dataset into a directory named after the dataset, using nested,
+// or writes it as a binary file if nested is nil
+func processNestedFile(in io.Reader, kind unloadfile.PayloadKind, targetDir string, xmf xmit.XmitFileParams, nested unloadfile.NestedFunc) (*unloadfile.ExtractedFile, error) {
+	var payload []byte
+	numRecords, err := ReadRecords(in, func(record []byte) error {
+		payload = unloadfile.AppendPayload(payload, kind, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	name := sequentialFileName(xmf)
+	file := &unloadfile.ExtractedFile{
+		Name:    name,
+		Payload: kind.Extension(),
+		Records: numRecords,
+	}
+	digest := unloadfile.NewOutputDigest()
+	digest.Write(payload)
+	digest.Fill(file)
+
+	if nested != nil {
+		file.Path = filepath.Join(targetDir, name)
+		log.Infof("Dataset %s holds a %s, unpacking it into %s\n", name, kind, file.Path)
+		return file, nested(kind, payload, file.Path)
+	}
+	file.Path = filepath.Join(targetDir, name+"."+kind.Extension())
+	log.Infof("Dataset %s holds a %s, writing file %s\n", name, kind, file.Path)
+	return file, os.WriteFile(file.Path, payload, 0644)
+}
+
+// ReadRecords calls recordFunc with every record of a sequential file unload,
+// and returns the number of records read. The input is read through a buffer
+// and the record passed to recordFunc is only valid until it returns.
+func ReadRecords(inFile io.Reader, recordFunc func([]byte) error) (int, error) {
+	r := NewRecordReader(bufio.NewReaderSize(inFile, 64*1024))
+	buffer := make([]byte, 0, 32768)
+	for {
+		record, err := r.next(buffer)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return r.count - 1, err
+		}
+		buffer = record[:0]
+		if err := recordFunc(record); err != nil {
+			return r.count - 1, err
+		}
+	}
+	return r.count, nil
+}
+
+// RecordReader reads the records of a sequential file unload one at a time,
+// keeping track of their offset and number for the error messages
+type RecordReader struct {
+	r      io.Reader
+	offset int64 // Offset of the next record
+	count  int   // Number of records read
+	header [8]byte
+}
+
+func NewRecordReader(r io.Reader) *RecordReader {
+	return &RecordReader{r: r}
+}
+
+// Next reads the next record of a sequential file unload. It returns io.EOF
+// when there are no more records.
+func (r *RecordReader) Next() ([]byte, error) {
+	return r.next(nil)
+}
+
+// next reads the next record into buffer, which is grown if needed
+func (r *RecordReader) next(buffer []byte) ([]byte, error) {
+	offset := r.offset
+	_, err := io.ReadFull(r.r, r.header[:])
+	if err == io.EOF {
+		return nil, err
+	}
+	r.count++
+	if err != nil {
+		return nil, xmit.ReadError(err, offset, r.count, "record header")
+	}
+	recLen := binary.BigEndian.Uint16(r.header[0:2])
+	if recLen < 8 {
+		return nil, xmit.NewFormatError(xmit.ErrCorruptData, offset, r.count, nil, "invalid record length %d", recLen)
+	}
+	record := buffer[:0]
+	if cap(record) < int(recLen)-8 {
+		record = make([]byte, recLen-8)
+	}
+	record = record[:recLen-8]
+	if _, err := io.ReadFull(r.r, record); err != nil {
+		return nil, xmit.ReadError(err, offset, r.count, "record data")
+	}
+	r.offset += int64(recLen)
+	return record, nil
+}
+
+// sequentialFileName builds the output file name (without extension) from the
+// original dataset name.
+func sequentialFileName(xmf xmit.XmitFileParams) string {
+	name := strings.Trim(xmf.SourceDSName, " ")
+	if name == "" {
+		name = "SEQFILE"
+	}
+	return name
+}
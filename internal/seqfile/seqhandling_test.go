@@ -0,0 +1,83 @@
+package seqfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jguillaumes/xmit_reader/internal/unloadfile"
+	xmit "github.com/jguillaumes/xmit_reader/internal/xmitfile"
+)
+
+// sequentialUnload builds the unload of a sequential dataset with count
+// records of length bytes
+func sequentialUnload(count int, length int) []byte {
+	var unload bytes.Buffer
+	for i := range count {
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint16(header, uint16(length+8))
+		unload.Write(header)
+		unload.Write(bytes.Repeat([]byte{0xc1 + byte(i%9)}, length))
+	}
+	return unload.Bytes()
+}
+
+// BenchmarkReadRecords measures the throughput of the record reader
+func BenchmarkReadRecords(b *testing.B) {
+	data := sequentialUnload(10000, 80)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		n, err := ReadRecords(bytes.NewReader(data), func([]byte) error { return nil })
+		if err != nil || n != 10000 {
+			b.Fatalf("%d records read, %v", n, err)
+		}
+	}
+}
+
+// TestNestedDataset checks that a sequential dataset holding a XMIT file is
+// written as a binary file, or passed to the nested function
+func TestNestedDataset(t *testing.T) {
+	log.SetOutput(io.Discard)
+	inner, err := os.ReadFile(filepath.Join("..", "..", "data", "jgpjcl.xmit"))
+	if err != nil {
+		t.Skip("sample XMIT file not found")
+	}
+	var unload []byte
+	for r := inner; len(r) > 0; r = r[80:] {
+		unload = binary.BigEndian.AppendUint16(unload, 88)
+		unload = append(unload, 0, 0, 0, 0, 0, 0)
+		unload = append(unload, r[:80]...)
+	}
+	xmf := xmit.XmitFileParams{SourceDSName: "USER.RELFILE", SourceDsorg: "PS"}
+
+	dir := t.TempDir()
+	file, err := ProcessSequentialFile(bytes.NewReader(unload), dir, "txt", xmf, "IBM-1047", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Payload != "xmit" || file.Path != filepath.Join(dir, "USER.RELFILE.xmit") {
+		t.Errorf("written as %s, payload %q", file.Path, file.Payload)
+	}
+	if got, err := os.ReadFile(file.Path); err != nil || !bytes.Equal(got, inner) {
+		t.Errorf("binary file differs from the nested XMIT file: %v", err)
+	}
+
+	var nestedKind unloadfile.PayloadKind
+	var nestedPayload []byte
+	nested := func(kind unloadfile.PayloadKind, payload []byte, dir string) error {
+		nestedKind, nestedPayload = kind, payload
+		return nil
+	}
+	if _, err := ProcessSequentialFile(bytes.NewReader(unload), t.TempDir(), "txt", xmf, "IBM-1047", nested); err != nil {
+		t.Fatal(err)
+	}
+	if nestedKind != unloadfile.PayloadXMIT || !bytes.Equal(nestedPayload, inner) {
+		t.Errorf("nested function got a %s of %d bytes", nestedKind, len(nestedPayload))
+	}
+}
@@ -1,53 +1,280 @@
 package unloadfile
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
+	"sync"
 
 	"os"
 	"path/filepath"
 
 	log "github.com/sirupsen/logrus"
 
-	e "github.com/jguillaumes/go-encoding/encodings"
 	"github.com/jguillaumes/go-hexdump"
 	xmit "github.com/jguillaumes/xmit_reader/internal/xmitfile"
+	xu "github.com/jguillaumes/xmit_reader/internal/xmitutils"
 )
 
-var enc = e.NewEncoding()
+var enc = xu.SharedEncoding
 
-func GenerateFiles(mMap MemberMap, unlFile *os.File, outdir string, extension string, xmf xmit.XmitFileParams, encoding string) (int, error) {
-	numFiles := 0
-	var err error
-	for _, m := range mMap {
-		mName := m.MemberName
-		filepos := m.FilePtr
-		fileName := filepath.Join(outdir, strings.Trim(mName, " ")+"."+strings.Trim(extension, " "))
-
-		err = writeMember(unlFile, filepos, fileName, xmf, encoding)
-		if err == nil {
-			numFiles++
-		} else {
+// AliasPolicy tells how the aliases of a member are materialised
+type AliasPolicy int
+
+const (
+	AliasCopy     AliasPolicy = iota // Aliases are copies of the member file
+	AliasSymlink                     // Aliases are symbolic links to the member file
+	AliasHardlink                    // Aliases are hard links to the member file
+	AliasList                        // Aliases are only listed, no file is created
+)
+
+func ParseAliasPolicy(policy string) (AliasPolicy, error) {
+	switch strings.ToLower(policy) {
+	case "copy":
+		return AliasCopy, nil
+	case "symlink":
+		return AliasSymlink, nil
+	case "hardlink":
+		return AliasHardlink, nil
+	case "list":
+		return AliasList, nil
+	default:
+		return AliasCopy, fmt.Errorf("unknown alias policy %q", policy)
+	}
+}
+
+// GenerateFiles writes the selected members, and their aliases, into outdir.
+// Members are written concurrently by up to jobs workers; it returns the
+// members and aliases written, sorted by name. Members holding a XMIT file
+// or an IEBCOPY unload are unpacked by nested, or written as binary files if
+// it is nil.
+func GenerateFiles(mMap MemberMap, unlFile io.ReaderAt, outdir string, extension string, xmf xmit.XmitFileParams, encoding string, aliasPolicy AliasPolicy, filter *MemberFilter, jobs int, nested NestedFunc) ([]ExtractedFile, error) {
+	// Load the decoding table first, so an unknown encoding is reported
+	// once, before any worker starts writing members
+	if _, err := enc.GetDecodingTableFor(encoding); err != nil {
+		return nil, err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		written  []ExtractedFile
+		firstErr error
+	)
+	members := make(chan MemberEntry)
+	for range max(jobs, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range members {
+				files, err := generateMember(m, unlFile, outdir, extension, xmf, encoding, aliasPolicy, filter, nested)
+				mu.Lock()
+				written = append(written, files...)
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	// Members are dispatched in name order, so the first error found and the
+	// log are the same in every run
+	for _, m := range sortedMembers(mMap) {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
 			break
 		}
+		members <- m
 	}
-	return numFiles, err
+	close(members)
+	wg.Wait()
+	sort.Slice(written, func(i, j int) bool { return written[i].Name < written[j].Name })
+	return written, firstErr
 }
 
-func writeMember(f *os.File, fpos int64, outnam string, xmf xmit.XmitFileParams, encoding string) error {
-	log.Debugf("Writing member data to %s\n", outnam)
-	variableLength := (xmf.SourceRecfm[0] == 'V')
-	lrecl := xmf.SourceLrecl
-	recordBuffer := bytes.NewBuffer(make([]byte, 0, lrecl))
+// sortedMembers returns the entries of the member map sorted by member name
+func sortedMembers(mMap MemberMap) []MemberEntry {
+	sorted := make([]MemberEntry, 0, len(mMap))
+	for _, m := range mMap {
+		sorted = append(sorted, m)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MemberName < sorted[j].MemberName })
+	return sorted
+}
+
+// generateMember writes a member and its aliases if they are selected by the
+// filter. It returns the description of the member and aliases written.
+func generateMember(m MemberEntry, unlFile io.ReaderAt, outdir string, extension string, xmf xmit.XmitFileParams, encoding string, aliasPolicy AliasPolicy, filter *MemberFilter, nested NestedFunc) ([]ExtractedFile, error) {
+	mName := m.MemberName
+	aliases := make([]string, 0, len(m.Aliases))
+	for _, alias := range m.Aliases {
+		if filter.Selected(alias) {
+			aliases = append(aliases, alias)
+		}
+	}
+	if !filter.Selected(mName) {
+		if len(aliases) == 0 {
+			log.Debugf("Member %s not selected\n", mName)
+			return nil, nil
+		}
+		// Only some aliases are selected: the data is written under the first one
+		mName, aliases = aliases[0], aliases[1:]
+	}
+	if m.Stats != nil {
+		log.Debugf("Member %s: version %02d.%02d, changed %s by %s, %d lines\n", mName, m.Stats.Version, m.Stats.Modification,
+			m.Stats.Changed.Format("2006-01-02 15:04:05"), m.Stats.UserId, m.Stats.CurrentLines)
+	}
+
+	member := ExtractedFile{Name: strings.Trim(mName, " ")}
+	kind, err := memberPayload(unlFile, m, xmf, encoding)
+	if errors.Is(err, xmit.ErrMissingMemberData) {
+		// The member is skipped, but the rest of the dataset is extracted.
+		// Its aliases are not written either, as they have no data to share
+		log.Warnf("Member %s not written: %v\n", member.Name, err)
+		member.MissingData = true
+		member.warn(err.Error())
+		return []ExtractedFile{member}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("member %s: %w", member.Name, err)
+	}
+	if kind != PayloadRecords {
+		return writeNestedMember(kind, unlFile, m, outdir, member, aliases, xmf, encoding, aliasPolicy, nested)
+	}
+
+	member.Path = memberFileName(outdir, mName, extension)
+	if err := writeMember(unlFile, m, &member, xmf, encoding); err != nil {
+		return nil, fmt.Errorf("member %s: %w", member.Name, err)
+	}
+	return writeAliases(member, aliases, outdir, extension, aliasPolicy)
+}
+
+// writeAliases materialises the aliases of a member written to a file. It
+// returns the description of the member followed by its aliases.
+func writeAliases(member ExtractedFile, aliases []string, outdir string, extension string, aliasPolicy AliasPolicy) ([]ExtractedFile, error) {
+	files := []ExtractedFile{member}
+	for _, alias := range aliases {
+		aliasFile := member
+		aliasFile.Name = strings.Trim(alias, " ")
+		aliasFile.Alias = true
+		aliasFile.AliasOf = member.Name
+		aliasFile.Path = memberFileName(outdir, alias, extension)
+		if err := writeAlias(member.Path, aliasFile.Path, aliasPolicy); err != nil {
+			return files, err
+		}
+		if aliasPolicy == AliasList {
+			aliasFile.Path = ""
+		}
+		files = append(files, aliasFile)
+	}
+	return files, nil
+}
+
+// errFirstRecord stops reading a member after its first record
+var errFirstRecord = errors.New("first record read")
 
-	_, err := f.Seek(fpos, io.SeekStart)
+// memberPayload tells what the member holds, from its first record
+func memberPayload(f io.ReaderAt, m MemberEntry, xmf xmit.XmitFileParams, encoding string) (PayloadKind, error) {
+	kind := PayloadRecords
+	err := ReadMemberRecords(f, m, xmf, encoding, func(record []byte) error {
+		kind = DetectPayload(record)
+		return errFirstRecord
+	})
+	if err != nil && err != errFirstRecord {
+		return kind, err
+	}
+	return kind, nil
+}
+
+// writeNestedMember unpacks the XMIT file or IEBCOPY unload held by a member
+// into a directory named after the member, using nested. If nested is nil it
+// is written, with its aliases, as a binary file that can be processed
+// later. It returns the description of the member and aliases written.
+func writeNestedMember(kind PayloadKind, f io.ReaderAt, m MemberEntry, outdir string, member ExtractedFile, aliases []string, xmf xmit.XmitFileParams, encoding string, aliasPolicy AliasPolicy, nested NestedFunc) ([]ExtractedFile, error) {
+	var payload []byte
+	err := ReadMemberRecords(f, m, xmf, encoding, func(record []byte) error {
+		payload = AppendPayload(payload, kind, record)
+		member.Records++
+		return nil
+	})
 	if err != nil {
+		return nil, fmt.Errorf("member %s: %w", member.Name, err)
+	}
+	member.Payload = kind.Extension()
+	digest := NewOutputDigest()
+	digest.Write(payload)
+	digest.Fill(&member)
+
+	mName := member.Name
+	if nested != nil {
+		member.Path = filepath.Join(outdir, mName)
+		log.Infof("Member %s holds a %s, unpacking it into %s\n", mName, kind, member.Path)
+		for _, alias := range aliases {
+			log.Infof("Alias %s of unpacked member %s not written\n", strings.Trim(alias, " "), mName)
+		}
+		if err := nested(kind, payload, member.Path); err != nil {
+			return nil, fmt.Errorf("member %s: %w", mName, err)
+		}
+		return []ExtractedFile{member}, nil
+	}
+
+	member.Path = memberFileName(outdir, mName, kind.Extension())
+	log.Infof("Member %s holds a %s, writing file %s\n", mName, kind, member.Path)
+	if err := os.WriteFile(member.Path, payload, 0644); err != nil {
+		return nil, err
+	}
+	return writeAliases(member, aliases, outdir, kind.Extension(), aliasPolicy)
+}
+
+func memberFileName(outdir string, mName string, extension string) string {
+	return filepath.Join(outdir, strings.Trim(mName, " ")+"."+strings.Trim(extension, " "))
+}
+
+// writeAlias materialises an alias of the member written to memberFile
+func writeAlias(memberFile string, aliasFile string, aliasPolicy AliasPolicy) error {
+	if aliasPolicy == AliasList {
+		log.Infof("Alias %s of %s not written\n", aliasFile, memberFile)
+		return nil
+	}
+	if err := os.Remove(aliasFile); err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	endMember := false
+	log.Infof("Writing alias %s of %s\n", aliasFile, memberFile)
+	switch aliasPolicy {
+	case AliasSymlink:
+		return os.Symlink(filepath.Base(memberFile), aliasFile)
+	case AliasHardlink:
+		return os.Link(memberFile, aliasFile)
+	default:
+		src, err := os.Open(memberFile)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := os.Create(aliasFile)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			dst.Close()
+			return err
+		}
+		return dst.Close()
+	}
+}
+
+// writeMember converts the records of a member into a text file. The number
+// of records, the size and checksum of the file and any problem found are
+// recorded in member.
+func writeMember(f io.ReaderAt, m MemberEntry, member *ExtractedFile, xmf xmit.XmitFileParams, encoding string) error {
+	outnam := member.Path
+	log.Debugf("Writing member data to %s\n", outnam)
 
 	memberFile, err := os.Create(outnam)
 
@@ -59,74 +286,214 @@ func writeMember(f *os.File, fpos int64, outnam string, xmf xmit.XmitFileParams,
 	log.Infof("Writing file %s\n", outnam)
 	defer memberFile.Close()
 
-	for !endMember {
-		blockheader := make([]byte, 8)
-		nBlockRead, err := f.Read(blockheader)
-		if err != nil {
-			return err
+	warn := func(format string, args ...any) {
+		msg := strings.TrimSpace(fmt.Sprintf(format, args...))
+		log.Warnf("Member %s: %s\n", member.Name, msg)
+		member.warn(msg)
+	}
+	digest := NewOutputDigest()
+	out := bufio.NewWriter(io.MultiWriter(memberFile, digest))
+	err = readMemberRecords(f, m, xmf, encoding, warn, func(record []byte) error {
+		recordLine, _ := enc.DecodeBytes(record, encoding)
+		out.WriteString(recordLine)
+		member.Records++
+		return out.WriteByte('\n')
+	})
+	if err != nil {
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	digest.Fill(member)
+	return memberFile.Close()
+}
+
+// ReadMemberRecords calls recordFunc with every logical record of the member
+// m. The records are deblocked according to the RECFM and LRECL of the
+// original dataset; variable length records are passed without their RDW.
+func ReadMemberRecords(f io.ReaderAt, m MemberEntry, xmf xmit.XmitFileParams, encoding string, recordFunc func([]byte) error) error {
+	return readMemberRecords(f, m, xmf, encoding, log.Warnf, recordFunc)
+}
+
+// readMemberRecords is ReadMemberRecords reporting the problems found while
+// deblocking the records through warn
+func readMemberRecords(f io.ReaderAt, m MemberEntry, xmf xmit.XmitFileParams, encoding string, warn func(string, ...any), recordFunc func([]byte) error) error {
+	if err := checkRecfm(xmf); err != nil {
+		return err
+	}
+	variableLength := (xmf.SourceRecfm[0] == 'V')
+	lrecl := int(xmf.SourceLrecl)
+	return readMemberBlocks(f, m, encoding, func(block []byte) error {
+		if variableLength {
+			return deblockVariable(block, recordFunc)
 		}
-		if nBlockRead != 8 {
-			return fmt.Errorf("expected to read 8 bytes, got %d", nBlockRead)
+		return deblockFixed(block, lrecl, warn, recordFunc)
+	})
+}
+
+// checkRecfm tells if the records of a dataset with the attributes in xmf
+// can be deblocked. Spanned variable length records are not supported.
+func checkRecfm(xmf xmit.XmitFileParams) error {
+	recfm := xmf.SourceRecfm
+	switch {
+	case recfm == "":
+		return xmit.NewFormatError(xmit.ErrUnsupportedRecfm, 0, 0, nil, "the record format of %s is not known", xmf.SourceDSName)
+	case recfm[0] == 'V' && strings.ContainsRune(recfm, 'S'):
+		return xmit.NewFormatError(xmit.ErrUnsupportedRecfm, 0, 0, nil, "RECFM=%s", recfm)
+	case recfm[0] != 'F' && recfm[0] != 'V' && recfm[0] != 'U':
+		return xmit.NewFormatError(xmit.ErrUnsupportedRecfm, 0, 0, nil, "RECFM=%s", recfm)
+	}
+	return nil
+}
+
+// memberSize returns the number of bytes in the data blocks of the member
+func memberSize(f io.ReaderAt, m MemberEntry, encoding string) (int64, error) {
+	var size int64
+	err := readMemberBlocks(f, m, encoding, func(block []byte) error {
+		size += int64(len(block))
+		return nil
+	})
+	return size, err
+}
+
+// readMemberBlocks calls blockFunc with every data block of the member m,
+// until the end of member marker is found. The block passed to blockFunc is
+// only valid until it returns.
+func readMemberBlocks(f io.ReaderAt, m MemberEntry, encoding string, blockFunc func([]byte) error) error {
+	if m.FilePtr == 0 {
+		return xmit.NewFormatError(xmit.ErrMissingMemberData, -1, 0, nil, "no data block found in the unload for TTR %04x%02x", m.Track, m.Offset)
+	}
+	endMember := false
+	var blockheader [8]byte
+	var buffer []byte
+	fpos := m.FilePtr
+	recNum := m.FileRecord
+	skip := m.FileBlock // The member can start after other blocks in its first record
+
+	for ; !endMember; recNum++ {
+		if err := readAtFull(f, blockheader[:], fpos); err != nil {
+			return xmit.ReadError(err, fpos, recNum, "member data")
 		}
 		blocklen := binary.BigEndian.Uint16(blockheader[0:2])
-		memberslen := blocklen - 8
-		buffer := make([]byte, memberslen)
-		nBlockRead, err = f.Read(buffer)
-		if err != nil {
-			return err
+		memberslen := int(blocklen) - 8
+		if memberslen < 0 {
+			return xmit.NewFormatError(xmit.ErrCorruptData, fpos, recNum, nil, "invalid record length %d", blocklen)
+		}
+		// The buffer is reused for every unload record of the member
+		if cap(buffer) < memberslen {
+			buffer = make([]byte, memberslen, max(memberslen, 32768))
 		}
-		if nBlockRead != int(memberslen) {
-			return fmt.Errorf("expected to read %d bytes, got %d", memberslen, nBlockRead)
+		buffer = buffer[:memberslen]
+		if err := readAtFull(f, buffer, fpos+8); err != nil {
+			return xmit.ReadError(err, fpos, recNum, "member data")
 		}
+		recordOffset := fpos
+		fpos += int64(blocklen)
+		// An unload record can hold several data blocks, each one
+		// preceded by its 12 byte header (F MBB CCHHR KL DL)
 		b := bytes.NewBuffer(buffer)
-		hdr := b.Next(12) // Block header
-		blockFlag := hdr[0]
-		if blockFlag != 0x00 && blockFlag != 0x80 { //0x80 is end block of unloaded PDSE
-			// Non member data block (notes or extended attributes), ignored
-			log.Debugf("Non data bloc: %02x\n", blockFlag)
-			continue
-		} else if memberslen == 12 {
-			// End of member marker
-			endMember = true
-			log.Debugf("EOB found")
-			break
-		} else {
-			log.Debugf("Beginning of block")
-		}
-		log.Tracef("\n%s\n", hexdump.HexDump(hdr, encoding))
-
-		recordBuffer.Reset()
-		remainingRecord := int(lrecl) // Remaining record bytes to read
-		for memberslen > 0 {
-			blockSize := int(min(memberslen, 362))
-			log.Tracef("Start of MDB")
-			block := b.Next(blockSize)
-			bb := bytes.NewBuffer(block)
-
-			sliceLen := blockSize
-			for nb := sliceLen; nb > 0; {
-				if variableLength {
-					log.Fatalln("Variable length records are not supported yet...")
-					panic("Variable length records are not supported yet")
-				} else {
-					n, _ := recordBuffer.Write(bb.Next(remainingRecord))
-					if n == 0 {
-						// End of data reached
-						break
-					}
-					remainingRecord -= n
-					recordLine, _ := enc.DecodeBytes(recordBuffer.Bytes(), encoding)
-					if remainingRecord == 0 {
-						fmt.Fprintln(memberFile, recordLine)
-						recordBuffer.Reset()
-						remainingRecord = int(lrecl)
-					}
-					nb -= n
-				}
+		b.Next(skip)
+		skip = 0
+		for b.Len() >= 12 && !endMember {
+			hdr := b.Next(12) // Block header
+			blockFlag := hdr[0]
+			dataLen := int(binary.BigEndian.Uint16(hdr[10:12]))
+			if dataLen > b.Len() {
+				return xmit.NewFormatError(xmit.ErrCorruptData, recordOffset, recNum, nil, "data block of %d bytes exceeds its unload record", dataLen)
+			}
+			block := b.Next(dataLen)
+			if blockFlag != 0x00 && blockFlag != 0x80 { //0x80 is end block of unloaded PDSE
+				// Non member data block (notes or extended attributes), ignored
+				log.Debugf("Non data bloc: %02x\n", blockFlag)
+				continue
+			} else if dataLen == 0 {
+				// End of member marker
+				endMember = true
+				log.Debugf("EOB found")
+				break
+			} else {
+				log.Debugf("Beginning of block")
+			}
+			if log.IsLevelEnabled(log.TraceLevel) {
+				log.Tracef("\n%s\n", hexdump.HexDump(hdr, encoding))
+			}
+
+			if err := blockFunc(block); err != nil {
+				return locateError(err, recordOffset, recNum)
 			}
-			memberslen -= uint16(blockSize)
 		}
 	}
-	memberFile.Close()
+	return nil
+}
+
+// readAtFull reads len(p) bytes at offset off. It returns io.EOF if there is
+// no data at that offset and io.ErrUnexpectedEOF if there is less than needed.
+func readAtFull(f io.ReaderAt, p []byte, off int64) error {
+	n, err := f.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == io.EOF && n > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// locateError sets the offset and record number of a FormatError found in a
+// data block, which does not know where it comes from. Other errors are
+// returned as they are.
+func locateError(err error, offset int64, recNum int) error {
+	var formatErr *xmit.FormatError
+	if errors.As(err, &formatErr) && formatErr.Record == 0 {
+		formatErr.Offset = offset
+		formatErr.Record = recNum
+	}
+	return err
+}
+
+// deblockFixed splits a RECFM=F/FB data block into its logical records.
+// Trailing bytes that do not make a full record are reported through warn.
+func deblockFixed(block []byte, lrecl int, warn func(string, ...any), recordFunc func([]byte) error) error {
+	if lrecl <= 0 {
+		lrecl = len(block)
+	}
+	for len(block) >= lrecl && len(block) > 0 {
+		if err := recordFunc(block[:lrecl]); err != nil {
+			return err
+		}
+		block = block[lrecl:]
+	}
+	if len(block) > 0 {
+		warn("Ignoring %d trailing bytes in fixed length block\n", len(block))
+	}
+	return nil
+}
+
+// deblockVariable splits a RECFM=V/VB data block into its logical records.
+// The block starts with a block descriptor word (BDW) and each record is
+// prefixed by its record descriptor word (RDW).
+func deblockVariable(block []byte, recordFunc func([]byte) error) error {
+	if len(block) < 4 {
+		return xmit.NewFormatError(xmit.ErrCorruptData, 0, 0, nil, "variable length block too short: %d bytes", len(block))
+	}
+	blockLen := int(binary.BigEndian.Uint16(block[0:2]))
+	if blockLen < 4 || blockLen > len(block) {
+		return xmit.NewFormatError(xmit.ErrCorruptData, 0, 0, nil, "invalid BDW length %d for a block of %d bytes", blockLen, len(block))
+	}
+	data := block[4:blockLen]
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return xmit.NewFormatError(xmit.ErrCorruptData, 0, 0, nil, "truncated RDW, %d bytes left in block", len(data))
+		}
+		recLen := int(binary.BigEndian.Uint16(data[0:2]))
+		if recLen < 4 || recLen > len(data) {
+			return xmit.NewFormatError(xmit.ErrCorruptData, 0, 0, nil, "invalid RDW length %d, %d bytes left in block", recLen, len(data))
+		}
+		if err := recordFunc(data[4:recLen]); err != nil {
+			return err
+		}
+		data = data[recLen:]
+	}
 	return nil
 }
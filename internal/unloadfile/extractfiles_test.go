@@ -0,0 +1,200 @@
+package unloadfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	xmit "github.com/jguillaumes/xmit_reader/internal/xmitfile"
+)
+
+// BenchmarkReadMemberRecords measures the deblocking of every member of the
+// sample PDS unload
+func BenchmarkReadMemberRecords(b *testing.B) {
+	log.SetOutput(io.Discard)
+	data, err := os.ReadFile(filepath.Join("..", "..", "data", "jgppds.unl"))
+	if err != nil {
+		b.Skip("sample unload file not found")
+	}
+	in := bytes.NewReader(data)
+	members, _, _, err := ReadUnloadDirectory(in, "IBM-1047")
+	if err != nil {
+		b.Fatal(err)
+	}
+	xmf := xmit.XmitFileParams{SourceDsorg: "PO", SourceRecfm: "FB", SourceLrecl: 80, SourceBlksize: 23440}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, m := range members {
+			if m.FilePtr == 0 {
+				continue
+			}
+			if err := ReadMemberRecords(in, m, xmf, "IBM-1047", func([]byte) error { return nil }); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// testUnload returns an IEBCOPY unload, with the 8 byte record headers, of
+// a FB 80 PDS holding ALPHA, with two lines, and EMPTY, with no records.
+// The directory entry of GHOST points at a track with no data.
+func testUnload(t *testing.T) []byte {
+	t.Helper()
+	members := []UnloadMember{{Name: "ALPHA"}, {Name: "EMPTY"}, {Name: "GHOST"}}
+	for _, line := range []string{"FIRST LINE", "SECOND LINE"} {
+		record, err := enc.EncodeString(fmt.Sprintf("%-80s", line), "IBM-1047")
+		if err != nil {
+			t.Fatal(err)
+		}
+		members[0].Records = append(members[0].Records, record)
+	}
+	var records [][]byte
+	_, err := CreateUnload(members, UnloadParams{Recfm: "FB", Lrecl: 80, Blksize: 800, Encoding: "IBM-1047"}, func(r []byte) error {
+		records = append(records, bytes.Clone(r))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ghost, _ := enc.EncodeString("GHOST   ", "IBM-1047")
+	var unload []byte
+	for _, r := range records {
+		if i := bytes.LastIndex(r, ghost); i >= 0 {
+			copy(r[i+8:i+11], []byte{0x00, 0x7f, 0x01})
+		}
+		unload = AppendPayload(unload, PayloadUnload, r)
+	}
+	return unload
+}
+
+// TestMissingData checks that an empty member is written as an empty file,
+// and that a member whose TTR points at no data is reported and skipped
+// without stopping the extraction
+func TestMissingData(t *testing.T) {
+	log.SetOutput(io.Discard)
+	unload := testUnload(t)
+	xmf := xmit.XmitFileParams{SourceDsorg: "PO", SourceRecfm: "FB", SourceLrecl: 80, SourceBlksize: 800}
+
+	dir := t.TempDir()
+	extraction, err := ProcessUnloadFile(bytes.NewReader(unload), dir, "txt", xmf, "IBM-1047", AliasCopy, nil, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := extraction.NumMembers(); n != 2 {
+		t.Errorf("%d members written, want 2", n)
+	}
+	files := make(map[string]ExtractedFile)
+	for _, f := range extraction.Members {
+		files[f.Name] = f
+	}
+	if f := files["ALPHA"]; f.Records != 2 || f.MissingData {
+		t.Errorf("ALPHA: %+v", f)
+	}
+	if f := files["EMPTY"]; f.Records != 0 || f.MissingData || f.Path == "" {
+		t.Errorf("EMPTY: %+v", f)
+	}
+	if f := files["GHOST"]; !f.MissingData || f.Path != "" || len(f.Warnings) == 0 {
+		t.Errorf("GHOST: %+v", f)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "EMPTY.txt")); err != nil || len(data) != 0 {
+		t.Errorf("EMPTY.txt: %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "GHOST.txt")); err == nil {
+		t.Error("GHOST.txt written")
+	}
+
+	list, err := ListUnloadFile(bytes.NewReader(unload), "IBM-1047")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range list {
+		if m.MissingData != (m.Name == "GHOST") {
+			t.Errorf("%s listed with missing data %v", m.Name, m.MissingData)
+		}
+		if m.Name == "EMPTY" && m.Size != 0 {
+			t.Errorf("EMPTY listed with %d bytes", m.Size)
+		}
+	}
+}
+
+// TestPackedBlocks extracts an unload whose records hold several data
+// blocks, so BETA starts after the end of ALPHA and the end-of-member
+// marker of EMPTY follows the end of BETA in the same record
+func TestPackedBlocks(t *testing.T) {
+	log.SetOutput(io.Discard)
+	members := []UnloadMember{{Name: "ALPHA"}, {Name: "BETA"}, {Name: "EMPTY"}}
+	for i, line := range []string{"FIRST LINE", "SECOND LINE", "THIRD LINE"} {
+		record, err := enc.EncodeString(fmt.Sprintf("%-80s", line), "IBM-1047")
+		if err != nil {
+			t.Fatal(err)
+		}
+		members[i/2].Records = append(members[i/2].Records, record)
+	}
+	var records [][]byte
+	_, err := CreateUnload(members, UnloadParams{Recfm: "FB", Lrecl: 80, Blksize: 800, Encoding: "IBM-1047"}, func(r []byte) error {
+		records = append(records, bytes.Clone(r))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// COPYR1, COPYR2 and the directory, whose blocks have a key, are kept
+	// as they are. The data blocks, written one to a record in name order,
+	// are packed as ALPHA data, ALPHA end, BETA data | BETA end, EMPTY end.
+	var unload []byte
+	var packed []byte
+	n := 0
+	for i, r := range records {
+		if i < 2 || r[9] != 0 {
+			unload = AppendPayload(unload, PayloadUnload, r)
+			continue
+		}
+		packed = append(packed, r...)
+		if n++; n == 3 {
+			unload = AppendPayload(unload, PayloadUnload, packed)
+			packed = nil
+		}
+	}
+	unload = AppendPayload(unload, PayloadUnload, packed)
+	xmf := xmit.XmitFileParams{SourceDsorg: "PO", SourceRecfm: "FB", SourceLrecl: 80, SourceBlksize: 800}
+
+	dir := t.TempDir()
+	extraction, err := ProcessUnloadFile(bytes.NewReader(unload), dir, "txt", xmf, "IBM-1047", AliasCopy, nil, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"ALPHA": fmt.Sprintf("%-80s\n%-80s\n", "FIRST LINE", "SECOND LINE"),
+		"BETA":  fmt.Sprintf("%-80s\n", "THIRD LINE"),
+		"EMPTY": "",
+	}
+	if len(extraction.Members) != len(want) {
+		t.Errorf("%d members written, want %d", len(extraction.Members), len(want))
+	}
+	for _, f := range extraction.Members {
+		if f.MissingData {
+			t.Errorf("%s: reported with missing data", f.Name)
+			continue
+		}
+		data, err := os.ReadFile(f.Path)
+		if err != nil || string(data) != want[f.Name] {
+			t.Errorf("%s: %q (%v), want %q", f.Name, data, err, want[f.Name])
+		}
+	}
+
+	list, err := ListUnloadFile(bytes.NewReader(unload), "IBM-1047")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range list {
+		if m.MissingData {
+			t.Errorf("%s listed with missing data", m.Name)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package unloadfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+)
+
+// ExtractedFile describes a file written by the extraction: a member, one of
+// its aliases or a sequential dataset. Records is the number of lines
+// written and Bytes and SHA256 describe the contents of the output file. A
+// member or dataset holding a nested XMIT file or IEBCOPY unload has its
+// kind in Payload; if it has been unpacked Path is the directory holding its
+// contents and Bytes and SHA256 describe the nested file. A member listed in
+// the directory whose data is not in the unload is not written: it has
+// MissingData set and no Path.
+type ExtractedFile struct {
+	Name        string   `json:"name"`
+	Path        string   `json:"path,omitempty"` // Empty for aliases and members that are not written
+	Alias       bool     `json:"alias"`
+	AliasOf     string   `json:"alias_of,omitempty"`
+	Payload     string   `json:"payload,omitempty"`
+	Records     int      `json:"records"`
+	Bytes       int64    `json:"bytes"`
+	SHA256      string   `json:"sha256"`
+	MissingData bool     `json:"missing_data,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+// warn records a warning about the file, once
+func (f *ExtractedFile) warn(msg string) {
+	for _, w := range f.Warnings {
+		if w == msg {
+			return
+		}
+	}
+	f.Warnings = append(f.Warnings, msg)
+}
+
+// Extraction is the result of the extraction of an IEBCOPY unload: its
+// COPYR1 and COPYR2 records and the members and aliases written, sorted by
+// name.
+type Extraction struct {
+	Copyr1  *Copyr1         `json:"copyr1"`
+	Copyr2  *Copyr2         `json:"copyr2"`
+	Members []ExtractedFile `json:"members"`
+}
+
+// NumMembers returns the number of members written, aliases not included
+func (x *Extraction) NumMembers() int {
+	n := 0
+	for _, m := range x.Members {
+		if !m.Alias && !m.MissingData {
+			n++
+		}
+	}
+	return n
+}
+
+// OutputDigest is a writer that computes the size and the SHA-256 of the
+// data written to an output file
+type OutputDigest struct {
+	hash hash.Hash
+	size int64
+}
+
+func NewOutputDigest() *OutputDigest {
+	return &OutputDigest{hash: sha256.New()}
+}
+
+func (d *OutputDigest) Write(p []byte) (int, error) {
+	d.hash.Write(p)
+	d.size += int64(len(p))
+	return len(p), nil
+}
+
+// Fill sets the size and checksum of f from the data written so far
+func (d *OutputDigest) Fill(f *ExtractedFile) {
+	f.Bytes = d.size
+	f.SHA256 = hex.EncodeToString(d.hash.Sum(nil))
+}
@@ -0,0 +1,115 @@
+package unloadfile
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Prefix of the patterns that are regular expressions instead of member masks
+const regexPrefix = "re:"
+
+// MemberFilter selects the members to be extracted. A member is selected if
+// it matches any of the include patterns, or there are none, and does not
+// match any of the exclude patterns. A nil filter selects every member.
+//
+// Patterns are TSO member masks, where * matches any number of characters
+// and % matches exactly one, unless they are prefixed by "re:", in which case
+// they are regular expressions matched against the whole member name.
+type MemberFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func NewMemberFilter(include []string, exclude []string) (*MemberFilter, error) {
+	f := &MemberFilter{}
+	for _, p := range include {
+		re, err := compilePattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, re)
+	}
+	for _, p := range exclude {
+		re, err := compilePattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	return f, nil
+}
+
+// Selected tells if the member name is selected by the filter
+func (f *MemberFilter) Selected(name string) bool {
+	if f == nil {
+		return true
+	}
+	name = strings.TrimRight(name, " ")
+	selected := len(f.include) == 0
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			selected = true
+			break
+		}
+	}
+	for _, re := range f.exclude {
+		if selected && re.MatchString(name) {
+			selected = false
+		}
+	}
+	return selected
+}
+
+// compilePattern converts a member mask or a "re:" prefixed regular
+// expression into a regular expression anchored at both ends
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if expr, found := strings.CutPrefix(pattern, regexPrefix); found {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid member regular expression %q: %w", expr, err)
+		}
+		return re, nil
+	}
+	mask := strings.ToUpper(strings.TrimSpace(pattern))
+	if mask == "" || len(mask) > 8 && !strings.Contains(mask, "*") {
+		return nil, fmt.Errorf("invalid member mask %q", pattern)
+	}
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, c := range mask {
+		switch c {
+		case '*':
+			expr.WriteString(".*")
+		case '%':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// ReadPatternFile reads member patterns from a file, one per line. Empty
+// lines and lines starting with # are ignored.
+func ReadPatternFile(fileName string) ([]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
@@ -0,0 +1,88 @@
+package unloadfile
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMemberFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		include  []string
+		exclude  []string
+		selected []string
+		rejected []string
+	}{
+		{"no patterns", nil, nil, []string{"ANY", "MEMBER"}, nil},
+		{"exact name", []string{"ALPHA"}, nil, []string{"ALPHA", "ALPHA   "}, []string{"ALPHAB", "XALPHA"}},
+		{"lower case mask", []string{"alpha"}, nil, []string{"ALPHA"}, []string{"BETA"}},
+		{"asterisk", []string{"JGP*"}, nil, []string{"JGP", "JGPPLI", "JGP$1"}, []string{"JG", "XJGP"}},
+		{"asterisk inside", []string{"A*Z"}, nil, []string{"AZ", "ABCZ"}, []string{"ABC", "ZA"}},
+		{"percent", []string{"A%C"}, nil, []string{"ABC", "A1C"}, []string{"AC", "ABBC"}},
+		{"special characters", []string{"$#@*"}, nil, []string{"$#@", "$#@X"}, []string{"X$#@"}},
+		{"several includes", []string{"A*", "B%"}, nil, []string{"ALPHA", "BX"}, []string{"BETA", "GAMMA"}},
+		{"regexp", []string{"re:JGP[FS].*"}, nil, []string{"JGPF", "JGPSUB"}, []string{"JGPA", "XJGPF"}},
+		{"regexp anchored", []string{"re:B|C"}, nil, []string{"B", "C"}, []string{"BC", "AB"}},
+		{"regexp with braces", []string{"re:A{1,3}"}, nil, []string{"A", "AAA"}, []string{"AAAA", "B"}},
+		{"only excludes", nil, []string{"TEST*"}, []string{"PROG", "ATEST"}, []string{"TEST", "TEST1"}},
+		{"exclude wins", []string{"A*"}, []string{"AB*"}, []string{"A", "AC"}, []string{"AB", "ABC"}},
+		{"exclude regexp wins", []string{"re:.*"}, []string{"re:.*[0-9]"}, []string{"ABC"}, []string{"ABC1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := NewMemberFilter(tc.include, tc.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tc.selected {
+				if !f.Selected(name) {
+					t.Errorf("%q not selected", name)
+				}
+			}
+			for _, name := range tc.rejected {
+				if f.Selected(name) {
+					t.Errorf("%q selected", name)
+				}
+			}
+		})
+	}
+
+	var nilFilter *MemberFilter
+	if !nilFilter.Selected("ANY") {
+		t.Error("nil filter does not select every member")
+	}
+}
+
+func TestMemberFilterErrors(t *testing.T) {
+	for _, pattern := range []string{"", "  ", "TOOLONGNAME", "re:A(", "re:[B"} {
+		if _, err := NewMemberFilter([]string{pattern}, nil); err == nil {
+			t.Errorf("include pattern %q accepted", pattern)
+		}
+		if _, err := NewMemberFilter(nil, []string{pattern}); err == nil {
+			t.Errorf("exclude pattern %q accepted", pattern)
+		}
+	}
+	// A long mask can only match member names if it has an asterisk
+	if _, err := NewMemberFilter([]string{"LONGNAME*X"}, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadPatternFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "members.txt")
+	text := "# Members to extract\nALPHA\n\n  JGP*  \n#BETA\nre:A{1,3}\n"
+	if err := os.WriteFile(fileName, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := ReadPatternFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ALPHA", "JGP*", "re:A{1,3}"}; !slices.Equal(patterns, want) {
+		t.Errorf("got %q, want %q", patterns, want)
+	}
+	if _, err := ReadPatternFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("missing file read")
+	}
+}
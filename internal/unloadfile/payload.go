@@ -0,0 +1,82 @@
+package unloadfile
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	xmit "github.com/jguillaumes/xmit_reader/internal/xmitfile"
+)
+
+// PayloadKind tells what the records of a member or a sequential dataset
+// hold. Distributions often carry XMIT files or IEBCOPY unloads inside the
+// members of a transmitted PDS, or inside sequential datasets (like the
+// SMP/E RELFILEs).
+type PayloadKind int
+
+const (
+	PayloadRecords PayloadKind = iota // Ordinary records, converted to text
+	PayloadXMIT                       // A nested XMIT file
+	PayloadUnload                     // A nested IEBCOPY unload
+)
+
+func (k PayloadKind) String() string {
+	switch k {
+	case PayloadXMIT:
+		return "XMIT file"
+	case PayloadUnload:
+		return "IEBCOPY unload"
+	default:
+		return "records"
+	}
+}
+
+// Extension returns the extension of the file where a nested payload of
+// this kind is written when it is not unpacked
+func (k PayloadKind) Extension() string {
+	switch k {
+	case PayloadXMIT:
+		return "xmit"
+	case PayloadUnload:
+		return "unload"
+	default:
+		return ""
+	}
+}
+
+// NestedFunc unpacks a XMIT file or IEBCOPY unload found in a member or a
+// sequential dataset into the directory dir. payload has the layout expected
+// by ProcessXMITFile or ReadUnloadDirectory, as built by AppendPayload.
+type NestedFunc func(kind PayloadKind, payload []byte, dir string) error
+
+// IsCopyr1 tells if record is the COPYR1 record of an IEBCOPY unload, without
+// the 8 byte record header
+func IsCopyr1(record []byte) bool {
+	return len(record) >= 4 && bytes.Equal(record[1:4], copyr1Eyecatcher)
+}
+
+// DetectPayload tells what the records of a member or dataset hold from its
+// first record: an INMR01 control record starts a XMIT file, and a COPYR1
+// record an IEBCOPY unload.
+func DetectPayload(record []byte) PayloadKind {
+	switch {
+	case xmit.IsXMIT(record):
+		return PayloadXMIT
+	case IsCopyr1(record):
+		return PayloadUnload
+	default:
+		return PayloadRecords
+	}
+}
+
+// AppendPayload appends a record of a nested payload to payload. The records
+// of a XMIT file are a stream of segments, so they are just concatenated.
+// Every record of an IEBCOPY unload gets the 8 byte header used by the
+// unloads built by ProcessXMITFile.
+func AppendPayload(payload []byte, kind PayloadKind, record []byte) []byte {
+	if kind == PayloadUnload {
+		var header [8]byte
+		binary.BigEndian.PutUint16(header[0:2], uint16(len(record)+8))
+		payload = append(payload, header[:]...)
+	}
+	return append(payload, record...)
+}
@@ -0,0 +1,70 @@
+package unloadfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// splitRecords returns the records of a file in the layout built by
+// AppendPayload for kind: 80 byte records for XMIT files, and the records
+// without their 8 byte headers for IEBCOPY unloads
+func splitRecords(data []byte, kind PayloadKind) [][]byte {
+	var records [][]byte
+	for len(data) > 0 {
+		switch kind {
+		case PayloadUnload:
+			length := int(binary.BigEndian.Uint16(data[0:2]))
+			records = append(records, data[8:length])
+			data = data[length:]
+		default:
+			n := min(80, len(data))
+			records = append(records, data[:n])
+			data = data[n:]
+		}
+	}
+	return records
+}
+
+func TestPayload(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		kind PayloadKind
+	}{
+		{"jgpjcl.xmit", PayloadXMIT},
+		{"jgpjcl.unload", PayloadUnload},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("..", "..", "data", tc.file))
+			if err != nil {
+				t.Skip("sample file not found")
+			}
+			records := splitRecords(data, tc.kind)
+			if kind := DetectPayload(records[0]); kind != tc.kind {
+				t.Fatalf("detected %s, want %s", kind, tc.kind)
+			}
+			var payload []byte
+			for _, r := range records {
+				payload = AppendPayload(payload, tc.kind, r)
+			}
+			if !bytes.Equal(payload, data) {
+				t.Errorf("payload of %d bytes differs from the original file of %d bytes", len(payload), len(data))
+			}
+		})
+	}
+}
+
+func TestDetectPayloadRecords(t *testing.T) {
+	for name, record := range map[string][]byte{
+		"empty":                nil,
+		"text":                 bytes.Repeat([]byte{0x40}, 80),
+		"short":                {0x00, 0xca},
+		"INMR01 not a control": {0x50, 0x00, 0xc9, 0xd5, 0xd4, 0xd9, 0xf0, 0xf1},
+	} {
+		if kind := DetectPayload(record); kind != PayloadRecords {
+			t.Errorf("%s record detected as %s", name, kind)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package unloadfile
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"sort"
+	"strings"
 
 	"encoding/json"
 	// "sort"
@@ -11,50 +13,178 @@ import (
 	// "encoding/json"
 	"fmt"
 	"io"
+	"math"
 
 	log "github.com/sirupsen/logrus"
 
-	"os"
-
 	"github.com/jguillaumes/go-hexdump"
 	xmit "github.com/jguillaumes/xmit_reader/internal/xmitfile"
 )
 
-func ProcessUnloadFile(inFile os.File, targetDir string, typeExt string, xmf xmit.XmitFileParams, encoding string) (int, error) {
+// ProcessUnloadFile extracts the members of an IEBCOPY unload into
+// targetDir. It returns the COPYR1 and COPYR2 records of the unload and the
+// members and aliases written, which are returned with the error too when
+// the extraction fails.
+func ProcessUnloadFile(inFile io.ReaderAt, targetDir string, typeExt string, xmf xmit.XmitFileParams, encoding string, aliasPolicy AliasPolicy, filter *MemberFilter, jobs int, nested NestedFunc) (*Extraction, error) {
+
+	members, c1, c2, err := ReadUnloadDirectory(inFile, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	written, err := GenerateFiles(members, inFile, targetDir, typeExt, xmf, encoding, aliasPolicy, filter, jobs, nested)
+	extraction := &Extraction{Copyr1: c1, Copyr2: c2, Members: written}
+	if err != nil {
+		return extraction, err
+	}
+
+	if log.GetLevel() == log.TraceLevel {
+
+		keys := make([]uint32, 0, len(members))
+		for k := range members {
+			keys = append(keys, k)
+		}
+
+		sort.SliceStable(keys, func(i, j int) bool {
+			//		return members[keys[i]].memberName < members[keys[j]].memberName
+			var ttri uint32 = (uint32(members[keys[i]].Track) << 8) + uint32(members[keys[i]].Offset)
+			var ttrj uint32 = (uint32(members[keys[j]].Track) << 8) + uint32(members[keys[j]].Offset)
+			return ttri < ttrj
+		})
+
+		for k := range keys {
+			m := members[keys[k]]
+			log.Printf("Member %-8s(%06x): TT: 0x%04x, R: 0x%02x, Ptr: %016x\n", m.MemberName, keys[k], m.Track, m.Offset, m.FilePtr)
+		}
+	}
+
+	if log.GetLevel() >= log.DebugLevel {
+		marshalled, _ := json.MarshalIndent(c1, "", "  ")
+		log.Debugf("COPYR1: %s\n", marshalled)
+		marshalled, _ = json.MarshalIndent(c2, "", "  ")
+		log.Debugf("COPYR2: %s\n", marshalled)
+	}
+	return extraction, nil
+}
+
+// ListUnloadFile returns the directory of an IEBCOPY unload, sorted by name.
+// Aliases are listed as separate entries pointing to their member.
+func ListUnloadFile(inFile io.ReaderAt, encoding string) ([]MemberInfo, error) {
+	members, _, _, err := ReadUnloadDirectory(inFile, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]MemberInfo, 0, len(members))
+	for ttr, m := range members {
+		var size int64
+		if m.FilePtr == 0 {
+			log.Warnf("No data found for member %s, TTR %06x\n", strings.TrimRight(m.MemberName, " "), ttr)
+		} else {
+			size, err = memberSize(inFile, m, encoding)
+			if err != nil {
+				return nil, fmt.Errorf("member %s: %w", m.MemberName, err)
+			}
+		}
+		list = append(list, MemberInfo{
+			Name:        strings.TrimRight(m.MemberName, " "),
+			TTR:         ttr,
+			Size:        size,
+			MissingData: m.FilePtr == 0,
+			Stats:       m.Stats,
+		})
+		for _, alias := range m.Aliases {
+			list = append(list, MemberInfo{
+				Name:        strings.TrimRight(alias, " "),
+				TTR:         ttr,
+				Alias:       true,
+				AliasOf:     strings.TrimRight(m.MemberName, " "),
+				Size:        size,
+				MissingData: m.FilePtr == 0,
+			})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// ReadUnloadDirectory reads the COPYR1 and COPYR2 records and the directory of
+// an IEBCOPY unload, and locates the data of every member
+func ReadUnloadDirectory(inFile io.ReaderAt, encoding string) (MemberMap, *Copyr1, *Copyr2, error) {
+	header := &unloadStream{r: io.NewSectionReader(inFile, 0, math.MaxInt64)}
+	members, c1, c2, err := readUnloadHeader(header, encoding)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	err = processDataRecords(inFile, header.offset, header.record, members, c1.TracksPerCyl, c1, c2, encoding)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return members, c1, c2, nil
+}
+
+// unloadStream reads an IEBCOPY unload sequentially, keeping track of the
+// offset and number of its records for the error messages
+type unloadStream struct {
+	r      io.Reader
+	offset int64 // Offset of the next byte to be read
+	record int   // Number of the current unload record, starting at 1
+}
+
+// readFull reads len(p) bytes of what. It returns io.EOF if the input ends
+// before the first byte, and a truncated input error if it ends later.
+func (s *unloadStream) readFull(p []byte, what string) error {
+	n, err := io.ReadFull(s.r, p)
+	if err == io.EOF {
+		return err
+	} else if err != nil {
+		return xmit.ReadError(err, s.offset, s.record, what)
+	}
+	s.offset += int64(n)
+	return nil
+}
+
+// readRecord reads the whole unload record, header included, that starts
+// the next record of the unload. The end of the input is a truncated input
+// error.
+func (s *unloadStream) readRecord(p []byte, what string) error {
+	s.record++
+	err := s.readFull(p, what)
+	if err == io.EOF {
+		return xmit.ReadError(err, s.offset, s.record, what)
+	}
+	return err
+}
+
+// readUnloadHeader reads the COPYR1 and COPYR2 records and the directory of
+// an IEBCOPY unload, leaving inFile positioned at the first data record
+func readUnloadHeader(inFile *unloadStream, encoding string) (MemberMap, *Copyr1, *Copyr2, error) {
 
 	//+
 	// Read COPYR1 record
 	//+
-	copyr1Buffer := bytes.NewBuffer(make([]byte, Copyr1_size))
-	n, err := inFile.Read(copyr1Buffer.Bytes())
-	if n != Copyr1_size || err != nil {
-		if err != nil {
-			return 0, fmt.Errorf("failed to read COPYR1 record: %w", err)
-		} else {
-			return 0, fmt.Errorf("expected %d bytes for COPYR1 record, got %d bytes", Copyr1_size, n)
-		}
+	copyr1Buffer := make([]byte, Copyr1_size)
+	if err := inFile.readRecord(copyr1Buffer, "COPYR1 record"); err != nil {
+		return nil, nil, nil, err
+	}
+	if !IsUnload(copyr1Buffer) {
+		return nil, nil, nil, xmit.NewFormatError(xmit.ErrCorruptDirectory, 0, inFile.record, nil, "COPYR1 eyecatcher not found")
 	}
-	c1, err := NewCopyr1(copyr1Buffer.Bytes())
+	c1, err := NewCopyr1(copyr1Buffer)
 	if err != nil {
-		return 0, err
+		return nil, nil, nil, err
 	}
 
 	//+
 	// Read COPYR2 record
 	//+
-	copyr2Buffer := bytes.NewBuffer(make([]byte, Copyr2_size))
-	n, err = inFile.Read(copyr2Buffer.Bytes())
-	if n != Copyr2_size || err != nil {
-		if err != nil {
-			return 0, fmt.Errorf("failed to read COPYR2 record: %w", err)
-		} else {
-			return 0, fmt.Errorf("expected %d bytes for COPYR2 record, got %d bytes", Copyr2_size, n)
-		}
+	copyr2Buffer := make([]byte, Copyr2_size)
+	if err := inFile.readRecord(copyr2Buffer, "COPYR2 record"); err != nil {
+		return nil, nil, nil, err
 	}
-	c2, err := NewCopyr2(copyr2Buffer.Bytes())
-	_ = c2
+	c2, err := NewCopyr2(copyr2Buffer)
 	if err != nil {
-		return 0, err
+		return nil, nil, nil, err
 	}
 
 	if log.GetLevel() >= log.DebugLevel {
@@ -66,7 +196,7 @@ func ProcessUnloadFile(inFile os.File, targetDir string, typeExt string, xmf xmi
 	}
 	dirBlocks, err := readDirBlocks(inFile)
 	if err != nil {
-		return 0, err
+		return nil, nil, nil, err
 	}
 
 	if log.GetLevel() == log.TraceLevel {
@@ -78,91 +208,53 @@ func ProcessUnloadFile(inFile os.File, targetDir string, typeExt string, xmf xmi
 
 	members, err := processDirBlocks(dirBlocks, encoding)
 	if err != nil {
-		return 0, err
+		return nil, nil, nil, err
 	}
 
 	if !c1.IsPdse() {
 		// Jump over 12 "unknown" bytes in PDS unload
 		dummyBuffer := make([]byte, 12)
-		inFile.Read(dummyBuffer)
-	}
-
-	err = processDataRecords(inFile, members, c1.TracksPerCyl, c1, c2, encoding)
-	if err != nil {
-		return 0, err
-	}
-
-	nfiles, err := GenerateFiles(members, &inFile, targetDir, typeExt, xmf, encoding)
-	if err != nil {
-		return 0, err
-	}
-
-	if log.GetLevel() == log.TraceLevel {
-
-		keys := make([]uint32, 0, len(members))
-		for k := range members {
-			keys = append(keys, k)
-		}
-
-		sort.SliceStable(keys, func(i, j int) bool {
-			//		return members[keys[i]].memberName < members[keys[j]].memberName
-			var ttri uint32 = (uint32(members[keys[i]].Track) << 8) + uint32(members[keys[i]].Offset)
-			var ttrj uint32 = (uint32(members[keys[j]].Track) << 8) + uint32(members[keys[j]].Offset)
-			return ttri < ttrj
-		})
-
-		for k := range keys {
-			m := members[keys[k]]
-			log.Printf("Member %-8s(%06x): TT: 0x%04x, R: 0x%02x, Ptr: %016x\n", m.MemberName, keys[k], m.Track, m.Offset, m.FilePtr)
+		if err := inFile.readFull(dummyBuffer, "directory record"); err != nil {
+			return nil, nil, nil, xmit.ReadError(err, inFile.offset, inFile.record, "directory record")
 		}
 	}
-
-	if log.GetLevel() >= log.DebugLevel {
-		marshalled, _ := json.MarshalIndent(c1, "", "  ")
-		log.Debugf("COPYR1: %s\n", marshalled)
-		marshalled, _ = json.MarshalIndent(c2, "", "  ")
-		log.Debugf("COPYR2: %s\n", marshalled)
-	}
-	return nfiles, nil
+	return members, c1, c2, nil
 }
 
-func readDirBlocks(inFile os.File) ([]DirBlock, error) {
+// readDirBlocks reads the directory records of the unload. Each one holds
+// a number of 276 byte directory blocks; a record whose length is not a
+// multiple of the block size is the last one.
+func readDirBlocks(inFile *unloadStream) ([]DirBlock, error) {
 	dirBlocks := make([]DirBlock, 0)
 	headerBuffer := make([]byte, 8)
 
 	endDirBlocks := false
 
 	for !endDirBlocks {
-		n, err := inFile.Read(headerBuffer)
-		if err != nil && err != io.EOF {
+		recordOffset := inFile.offset
+		if err := inFile.readRecord(headerBuffer, "directory record header"); err != nil {
 			return nil, err
-		} else if err == io.EOF {
-			endDirBlocks = true
-		} else if n != 8 {
-			return nil, fmt.Errorf("expected 8 bytes, read %d", n)
 		}
 
-		blockLen := binary.BigEndian.Uint16(headerBuffer[0:2]) - 8
+		recLen := binary.BigEndian.Uint16(headerBuffer[0:2])
+		if recLen < 8 {
+			return nil, xmit.NewFormatError(xmit.ErrCorruptDirectory, recordOffset, inFile.record, nil, "invalid directory record length %d", recLen)
+		}
+		blockLen := recLen - 8
 		if blockLen == 12 {
-			endDirBlocks = true
-			_, err = inFile.Read(make([]byte, 12))
-			if err != nil {
-				return nil, err
+			if err := inFile.readFull(make([]byte, 12), "directory record"); err != nil {
+				return nil, xmit.ReadError(err, inFile.offset, inFile.record, "directory record")
 			}
 			break
 		}
 		numBlocks := blockLen / DirBlock_size
-		for _ = range numBlocks {
+		for range numBlocks {
 			db := make([]byte, DirBlock_size)
-			_, err := inFile.Read(db)
-			if err == nil {
-				dirBlocks = append(dirBlocks, DirBlock(db))
-				log.Traceln(hexdump.HexDump(db, "IBM-1047"))
-			} else if err == io.EOF {
-				endDirBlocks = true
-			} else {
-				return nil, err
+			if err := inFile.readFull(db, "directory block"); err != nil {
+				return nil, xmit.ReadError(err, inFile.offset, inFile.record, "directory block")
 			}
+			dirBlocks = append(dirBlocks, DirBlock(db))
+			log.Traceln(hexdump.HexDump(db, "IBM-1047"))
 		}
 		if blockLen%DirBlock_size != 0 {
 			endDirBlocks = true
@@ -172,120 +264,207 @@ func readDirBlocks(inFile os.File) ([]DirBlock, error) {
 	return dirBlocks, nil
 }
 
+// processDirBlocks decodes the entries of the directory blocks. Every block
+// holds entries until an entry named X'FF's or the last entry named in the
+// block key.
 func processDirBlocks(blocks []DirBlock, encoding string) (MemberMap, error) {
 	entries := make(map[uint32]MemberEntry, len(blocks))
 
-	for _, b := range blocks {
+	for i, b := range blocks {
 		bbuff := bytes.NewBuffer(b[:])
 		_ = bbuff.Next(12)
 		lastEntry, _ := enc.DecodeBytes(bbuff.Next(8), encoding)
 		_ = bbuff.Next(2)
 		endBlock := false
 		for !endBlock {
+			if bbuff.Len() < 8 {
+				// The end of the block is the end of its entries
+				break
+			}
 			next8 := bbuff.Next(8)
 			if bytes.Equal(next8, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) {
 				break
 			}
+			if bbuff.Len() < 4 {
+				return nil, xmit.NewFormatError(xmit.ErrCorruptDirectory, 0, 0, nil, "truncated entry at the end of directory block %d", i+1)
+			}
 			currEntry, _ := enc.DecodeBytes(next8, encoding)
 			tt := binary.BigEndian.Uint16(bbuff.Next(2))
 			r, _ := bbuff.ReadByte()
-			entry := MemberEntry{
-				MemberName: currEntry,
-				Track:      tt,
-				Offset:     r,
+			c, _ := bbuff.ReadByte()
+			userDataBytes := c & 0b00011111 * 2 // A mainframe halfword = 2 bytes
+			if bbuff.Len() < int(userDataBytes) {
+				return nil, xmit.NewFormatError(xmit.ErrCorruptDirectory, 0, 0, nil, "user data of entry %s exceeds directory block %d", currEntry, i+1)
 			}
+			userData := bbuff.Next(int(userDataBytes))
 			ttr := uint32(tt)<<8 + uint32(r)
+			entry, found := entries[ttr]
+			if !found {
+				entry = MemberEntry{
+					Track:  tt,
+					Offset: r,
+				}
+			}
+			if c&0x80 != 0 || entry.MemberName != "" {
+				// Alias entry, or a second name pointing to the same data
+				log.Debugf("Alias %s found for TTR %06x\n", currEntry, ttr)
+				entry.Aliases = append(entry.Aliases, currEntry)
+			} else {
+				entry.MemberName = currEntry
+				// Source libraries keep the ISPF statistics in the user data
+				if len(userData) >= IspfStats_size {
+					stats, err := NewIspfStats(userData, encoding)
+					if err != nil {
+						log.Debugf("Member %s has no valid ISPF statistics: %v\n", currEntry, err)
+					} else {
+						entry.Stats = stats
+					}
+				}
+			}
 			entries[ttr] = entry
 			if currEntry == lastEntry {
 				break
 			}
-			// Skip user data if present
-			c, _ := bbuff.ReadByte()
-			userDataBytes := c & 0b00011111 * 2 // A mainframe halfword = 2 bytes
-			_ = bbuff.Next(int(userDataBytes))
+		}
+	}
+	// An alias whose real member has been deleted keeps the data alive:
+	// it is extracted under the name of its first alias
+	for ttr, entry := range entries {
+		if entry.MemberName == "" {
+			entry.MemberName = entry.Aliases[0]
+			entry.Aliases = entry.Aliases[1:]
+			log.Warnf("Alias %s has no real member, extracting it as a member\n", entry.MemberName)
+			entries[ttr] = entry
 		}
 	}
 	return entries, nil
 }
 
-func processDataRecords(inFile os.File, members MemberMap, tpc uint16, cr1 *Copyr1, cr2 *Copyr2, encoding string) error {
+// processDataRecords reads the data records of the unload, starting at
+// offset, and sets the position of the first one of every member. recNum is
+// the number of the last record read before offset.
+func processDataRecords(inFile io.ReaderAt, offset int64, recNum int, members MemberMap, tpc uint16, cr1 *Copyr1, cr2 *Copyr2, encoding string) error {
 
 	// Read rest of records
 	// The "header" portion is always 8 bytes
 	rechead := make([]byte, 8)
-	end_records := false
-	for !end_records {
-		currOffset, err := inFile.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return err
-		}
-		l, err := inFile.Read(rechead)
-		if l != 8 || err != nil {
-			if err == io.EOF {
-				break
-			}
-			log.Fatalf("Error reading record head, read %d byres: %v", l, err)
+	var record []byte
+	for {
+		currOffset := offset
+		recNum++
+		err := readAtFull(inFile, rechead, currOffset)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return xmit.ReadError(err, currOffset, recNum, "record header")
 		}
 		hbuff := bytes.NewBuffer(rechead)
 		reclen := binary.BigEndian.Uint16(hbuff.Next(2))
-		if reclen == 20 {
-			// This is an end-of-member marker record, we skip it
-			inFile.Read(make([]byte, 12))
-			continue
+		if reclen < 8 {
+			return xmit.NewFormatError(xmit.ErrCorruptData, currOffset, recNum, nil, "invalid record length %d", reclen)
+		}
+		offset += int64(reclen)
+		// A record of 20 bytes holds just an end-of-member marker. It is
+		// looked up like any other block: the TTR of an empty member points
+		// at its marker, and the member is read as having no records
+		if reclen < 20 {
+			return xmit.NewFormatError(xmit.ErrCorruptData, currOffset, recNum, nil, "record of %d bytes too short for a data block header", reclen)
 		}
 		_ = hbuff.Next(6)
-		// Next byte will tell us if we are dealing with a member data record
-		memberDataBuff := bytes.NewBuffer(make([]byte, reclen-8))
-		_, err = inFile.Read(memberDataBuff.Bytes())
+		// The record buffer is reused, it only grows for longer records
+		if cap(record) < int(reclen)-8 {
+			record = make([]byte, reclen-8)
+		}
+		memberDataBuff := bytes.NewBuffer(record[:reclen-8])
+		err = readAtFull(inFile, memberDataBuff.Bytes(), currOffset+8)
 		if err != nil {
-			return err
+			return xmit.ReadError(err, currOffset, recNum, "record data")
 		}
-		t, _ := memberDataBuff.ReadByte()
-		if t != 0x00 {
-			// Not a member data record, ignore
-			continue
-		} else {
-			memberDataBuff.Next(3)                                        // Skip MBB
-			cc := uint32(binary.BigEndian.Uint16(memberDataBuff.Next(2))) // Low 16 bits of cyl
-			hh := binary.BigEndian.Uint16(memberDataBuff.Next(2))         // 12 hi bits of cyl + 4 bits of track/head
-			cch := (hh & 0xFFF0) << 12                                    // Hi 12 bits of cyl (zero for non extended vols)
-			ccl := cc + uint32(cch)                                       // Full cylinder number
-			hht := 0x0F & hh                                              // Track/head number
-
-			tt, err := findRelativeTrack(ccl, hht, cr1, cr2)
+		// An unload record can hold several data blocks, each one preceded
+		// by its 12 byte header (F MBB CCHHR KL DL). A member can start in
+		// any of them, after the end of the previous member.
+		data := memberDataBuff.Bytes()
+		for pos := 0; pos+12 <= len(data); {
+			hdr := data[pos : pos+12]
+			blockPos := pos
+			pos += 12 + int(binary.BigEndian.Uint16(hdr[10:12]))
+			if hdr[0] != 0x00 {
+				// Not a member data block, ignore
+				continue
+			}
+			ttr, err := blockTTR(hdr[1:12], cr1, cr2)
 			if err != nil {
-				log.Warnf("Cannot find relative track for cyl=%04x, head=%04x", cc, hh)
+				log.Warnln(err)
 				continue
 			}
-			r, _ := memberDataBuff.ReadByte()
-			ttr := tt<<8 + uint32(r)
+			cc := binary.BigEndian.Uint16(hdr[4:6])
+			hh := binary.BigEndian.Uint16(hdr[6:8])
+			r := hdr[8]
+			block := data[blockPos+12 : min(pos, len(data))]
 			m, ok := members[ttr]
+			debug := log.IsLevelEnabled(log.DebugLevel)
 			if !ok {
-				log.Debugf("Member with ttr %04x:%02x not found. len=%d, offset=%d (%04x%04x%02x)\n", ttr>>8, ttr&0xff, reclen, currOffset, cc, hh, r)
-				log.Debugf("\n%s", hexdump.HexDump(memberDataBuff.Bytes()[0:64], encoding))
+				if debug {
+					log.Debugf("Member with ttr %04x:%02x not found. len=%d, offset=%d+%d (%04x%04x%02x)\n", ttr>>8, ttr&0xff, reclen, currOffset, blockPos, cc, hh, r)
+					log.Debugf("\n%s", hexdump.HexDump(block[0:min(64, len(block))], encoding))
+				}
 			} else {
-				log.Debugf("Member with ttr %04x:%02x found (%s), len=%d, offset=%d\n", ttr>>8, ttr&0xff, m.MemberName, reclen, currOffset)
-				log.Debugf("\n%s", hexdump.HexDump(memberDataBuff.Bytes()[0:64], encoding))
+				if debug {
+					log.Debugf("Member with ttr %04x:%02x found (%s), len=%d, offset=%d+%d\n", ttr>>8, ttr&0xff, m.MemberName, reclen, currOffset, blockPos)
+					log.Debugf("\n%s", hexdump.HexDump(block[0:min(64, len(block))], encoding))
+				}
 				m.FilePtr = currOffset
+				m.FileRecord = recNum
+				m.FileBlock = blockPos
 				members[ttr] = m
 			}
-
 		}
 	}
 	return nil
 }
 
+// blockTTR computes the TTR of a data block from the MBBCCHHR of its header.
+// The header starts after the flag byte.
+func blockTTR(header []byte, cr1 *Copyr1, cr2 *Copyr2) (uint32, error) {
+	if len(header) < 8 {
+		return 0, fmt.Errorf("data block header too short: %d bytes", len(header))
+	}
+	cc := binary.BigEndian.Uint16(header[3:5]) // Low 16 bits of cyl
+	hh := binary.BigEndian.Uint16(header[5:7]) // 12 hi bits of cyl + 4 bits of track/head
+
+	tt, err := findRelativeTrack(cylinderNumber(cc, hh), hh&0x0F, cr1, cr2)
+	if err != nil {
+		return 0, fmt.Errorf("cannot find relative track for cyl=%04x, head=%04x: %w", cc, hh, err)
+	}
+	return tt<<8 + uint32(header[7]), nil
+}
+
+// cylinderNumber returns the full cylinder number of a CCHH address. In
+// extended address volumes the 12 high bits of HH are the high bits of the
+// cylinder number; they are zero in other volumes.
+func cylinderNumber(cc uint16, hh uint16) uint32 {
+	return uint32(hh&0xFFF0)<<12 | uint32(cc)
+}
+
+// findRelativeTrack converts the cylinder and head of a track into its track
+// number relative to the start of the dataset. The extents of the dataset,
+// in the DEB saved in COPYR2, are in the order of its relative tracks; each
+// one may start and end in the middle of a cylinder.
 func findRelativeTrack(cc uint32, hh uint16, c1 *Copyr1, c2 *Copyr2) (uint32, error) {
-	exts := &c2.Extensions
+	tpc := uint64(c1.TracksPerCyl)
+	track := uint64(cc)*tpc + uint64(hh)
 
-	var relTrack uint32 = 0
-	for _, ext := range *exts {
-		if ext.StartCylinder <= cc && cc <= ext.EndCylinder {
-			relTrack += (cc-ext.StartCylinder)*uint32(c1.TracksPerCyl) + uint32(hh) - uint32(ext.StartTrack)
-			break
-		} else {
-			relTrack += uint32(c1.TracksPerCyl)
+	var relTrack uint64
+	for _, ext := range c2.Extensions {
+		if ext.NumTracks == 0 {
+			// Unused DEB extent
+			continue
+		}
+		start := uint64(ext.StartCylinder)*tpc + uint64(ext.StartTrack)
+		if start <= track && track-start < uint64(ext.NumTracks) {
+			return uint32(relTrack + track - start), nil
 		}
+		relTrack += uint64(ext.NumTracks)
 	}
-	return uint32(relTrack), nil
+	return 0, errors.New("track not in any of the dataset extents")
 }
@@ -0,0 +1,161 @@
+package unloadfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	xmit "github.com/jguillaumes/xmit_reader/internal/xmitfile"
+)
+
+// addSamples seeds the corpus of a fuzz target with the sample IEBCOPY
+// unloads in the data directory, and with every one of them cut in the
+// middle
+func addSamples(f *testing.F) {
+	log.SetOutput(io.Discard)
+	var names []string
+	for _, pattern := range []string{"*.unl", "*.unload"} {
+		matches, err := filepath.Glob(filepath.Join("..", "..", "data", pattern))
+		if err != nil {
+			f.Fatal(err)
+		}
+		names = append(names, matches...)
+	}
+	if len(names) == 0 {
+		f.Skip("no sample unload files found")
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data, "FB", int16(80))
+		f.Add(data[:len(data)/2], "VB", int16(255))
+	}
+}
+
+// checkError fails if a parser returns an error that is not an I/O error
+// or a FormatError
+func checkError(t *testing.T, err error) {
+	var formatErr *xmit.FormatError
+	if err != nil && !errors.As(err, &formatErr) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("unexpected error type %T: %v", err, err)
+	}
+}
+
+func FuzzReadUnloadDirectory(f *testing.F) {
+	addSamples(f)
+	f.Fuzz(func(t *testing.T, data []byte, recfm string, lrecl int16) {
+		xmf := xmit.XmitFileParams{SourceRecfm: recfm, SourceLrecl: lrecl}
+		members, _, _, err := ReadUnloadDirectory(bytes.NewReader(data), "IBM-1047")
+		checkError(t, err)
+		for _, m := range members {
+			err := ReadMemberRecords(bytes.NewReader(data), m, xmf, "IBM-1047", func([]byte) error { return nil })
+			checkError(t, err)
+		}
+	})
+}
+
+func FuzzUnloadReader(f *testing.F) {
+	addSamples(f)
+	f.Fuzz(func(t *testing.T, data []byte, recfm string, lrecl int16) {
+		xmf := xmit.XmitFileParams{SourceRecfm: recfm, SourceLrecl: lrecl}
+		u, err := NewUnloadReader(bytes.NewReader(data), xmf, "IBM-1047")
+		if err != nil {
+			checkError(t, err)
+			return
+		}
+		for {
+			_, _, err := u.NextMember()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				checkError(t, err)
+				return
+			}
+			for {
+				_, err := u.NextRecord()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					checkError(t, err)
+					return
+				}
+			}
+		}
+	})
+}
+
+// copyr2Data builds the data of a COPYR2 record with the given DEB extents
+func copyr2Data(extents []ExtensionData) []byte {
+	raw := make([]byte, Copyr2_size)
+	for i, ext := range extents {
+		e := raw[8+16+16*i:]
+		e[5] = byte(ext.NumTracks >> 16)
+		binary.BigEndian.PutUint16(e[6:8], uint16(ext.StartCylinder))
+		binary.BigEndian.PutUint16(e[8:10], uint16(ext.StartCylinder>>16)<<4|uint16(ext.StartTrack))
+		binary.BigEndian.PutUint16(e[10:12], uint16(ext.EndCylinder))
+		binary.BigEndian.PutUint16(e[12:14], uint16(ext.EndCylinder>>16)<<4|uint16(ext.EndTrack))
+		binary.BigEndian.PutUint16(e[14:16], uint16(ext.NumTracks))
+	}
+	return raw
+}
+
+func TestFindRelativeTrack(t *testing.T) {
+	c1 := &Copyr1{TracksPerCyl: 15}
+	c2, err := NewCopyr2(copyr2Data([]ExtensionData{
+		{StartCylinder: 10, StartTrack: 5, EndCylinder: 11, EndTrack: 9, NumTracks: 20}, // Relative tracks 0-19
+		{}, // Unused
+		{StartCylinder: 100, StartTrack: 0, EndCylinder: 100, EndTrack: 14, NumTracks: 15},   // 20-34
+		{StartCylinder: 70000, StartTrack: 3, EndCylinder: 70000, EndTrack: 7, NumTracks: 5}, // 35-39, EAV
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c2.Extensions[3].StartCylinder; got != 70000 {
+		t.Fatalf("EAV extent starts at cylinder %d, want 70000", got)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		cc    uint32
+		hh    uint16
+		track uint32
+		found bool
+	}{
+		{"start of a partial cylinder", 10, 5, 0, true},
+		{"end of the first cylinder", 10, 14, 9, true},
+		{"next cylinder", 11, 0, 10, true},
+		{"end of a partial cylinder", 11, 9, 19, true},
+		{"before the first extent", 10, 4, 0, false},
+		{"after the end of an extent", 11, 10, 0, false},
+		{"extent after an unused one", 100, 0, 20, true},
+		{"end of a full cylinder", 100, 14, 34, true},
+		{"EAV cylinder", 70000, 3, 35, true},
+		{"end of an EAV extent", 70000, 7, 39, true},
+		{"EAV cylinder without its high bits", 70000 & 0xFFFF, 3, 0, false},
+		{"track 0 of the volume", 0, 0, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			track, err := findRelativeTrack(tc.cc, tc.hh, c1, c2)
+			if found := err == nil; found != tc.found {
+				t.Fatalf("found %v (%v), want %v", found, err, tc.found)
+			}
+			if tc.found && track != tc.track {
+				t.Errorf("relative track %d, want %d", track, tc.track)
+			}
+		})
+	}
+
+	// The CCHHR of a data block in the EAV extent
+	header := []byte{0x00, 0x00, 0x00, 0x11, 0x70, 0x00, 0x17, 0x02}
+	if ttr, err := blockTTR(header, c1, c2); err != nil || ttr != 39<<8|2 {
+		t.Errorf("TTR %06x (%v), want %06x", ttr, err, 39<<8|2)
+	}
+}
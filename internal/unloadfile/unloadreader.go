@@ -0,0 +1,198 @@
+package unloadfile
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	xmit "github.com/jguillaumes/xmit_reader/internal/xmitfile"
+)
+
+// UnloadReader reads an IEBCOPY unload sequentially, member by member, so it
+// does not need to seek in its input. Members are returned in the order
+// their data appears in the unload, which is the order of their TTR.
+type UnloadReader struct {
+	Copyr1  *Copyr1
+	Copyr2  *Copyr2
+	Members MemberMap
+
+	r        *unloadStream
+	xmf      xmit.XmitFileParams
+	encoding string
+	record   []byte // Unprocessed blocks of the current unload record
+	inMember bool   // Positioned inside the data of a member
+	seen     map[uint32]bool
+	records  [][]byte // Logical records of the current block
+}
+
+// NewUnloadReader reads the COPYR1 and COPYR2 records and the directory of
+// the unload. xmf holds the attributes of the unloaded dataset.
+func NewUnloadReader(r io.Reader, xmf xmit.XmitFileParams, encoding string) (*UnloadReader, error) {
+	stream := &unloadStream{r: r}
+	members, c1, c2, err := readUnloadHeader(stream, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return &UnloadReader{
+		Copyr1:   c1,
+		Copyr2:   c2,
+		Members:  members,
+		r:        stream,
+		xmf:      xmf,
+		encoding: encoding,
+		seen:     make(map[uint32]bool, len(members)),
+	}, nil
+}
+
+// readRecord reads the next unload record, without its 8 byte header. It
+// returns io.EOF at the end of the unload.
+func (u *UnloadReader) readRecord() ([]byte, error) {
+	header := make([]byte, 8)
+	offset := u.r.offset
+	u.r.record++
+	if err := u.r.readFull(header, "record header"); err != nil {
+		return nil, err
+	}
+	recLen := int(binary.BigEndian.Uint16(header[0:2]))
+	if recLen < 8 {
+		return nil, xmit.NewFormatError(xmit.ErrCorruptData, offset, u.r.record, nil, "invalid unload record length %d", recLen)
+	}
+	record := make([]byte, recLen-8)
+	if err := u.r.readFull(record, "record data"); err != nil {
+		return nil, xmit.ReadError(err, u.r.offset, u.r.record, "record data")
+	}
+	return record, nil
+}
+
+// NextMember skips the rest of the current member and returns the directory
+// entry and TTR of the next one. Empty members are returned too, with no
+// records. It returns io.EOF when there are no more members in the unload;
+// the members whose data was not found are reported then.
+func (u *UnloadReader) NextMember() (*MemberEntry, uint32, error) {
+	for u.inMember {
+		if _, err := u.nextBlock(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+	}
+	u.records = nil
+	for {
+		if len(u.record) < 12 {
+			record, err := u.readRecord()
+			if err == io.EOF {
+				u.reportMissing()
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			u.record = record
+			continue
+		}
+		block := u.record
+		dataLen := int(binary.BigEndian.Uint16(block[10:12]))
+		u.record = u.record[min(12+dataLen, len(u.record)):]
+		if block[0] != 0x00 {
+			continue
+		}
+		// End-of-member markers are looked up too: the TTR of an empty
+		// member points at its marker
+		ttr, err := blockTTR(block[1:12], u.Copyr1, u.Copyr2)
+		if err != nil {
+			log.Warnln(err)
+			continue
+		}
+		m, found := u.Members[ttr]
+		if !found || u.seen[ttr] {
+			log.Debugf("Data block with TTR %06x does not start a member\n", ttr)
+			continue
+		}
+		// Leave the first block to be returned by nextBlock
+		u.record = block
+		u.seen[ttr] = true
+		u.inMember = true
+		return &m, ttr, nil
+	}
+}
+
+// reportMissing warns about the members whose data has not been found in
+// the unload
+func (u *UnloadReader) reportMissing() {
+	for _, m := range sortedMembers(u.Members) {
+		ttr := uint32(m.Track)<<8 + uint32(m.Offset)
+		if !u.seen[ttr] {
+			log.Warnf("No data found for member %s, TTR %06x\n", strings.TrimRight(m.MemberName, " "), ttr)
+		}
+	}
+}
+
+// nextBlock returns the next data block of the current member, or io.EOF at
+// the end of the member
+func (u *UnloadReader) nextBlock() ([]byte, error) {
+	if !u.inMember {
+		return nil, io.EOF
+	}
+	for {
+		if len(u.record) < 12 {
+			record, err := u.readRecord()
+			if err == io.EOF {
+				return nil, xmit.ReadError(err, u.r.offset, u.r.record, "member data")
+			} else if err != nil {
+				return nil, err
+			}
+			u.record = record
+			continue
+		}
+		blockFlag := u.record[0]
+		dataLen := int(binary.BigEndian.Uint16(u.record[10:12]))
+		if 12+dataLen > len(u.record) {
+			return nil, xmit.NewFormatError(xmit.ErrCorruptData, u.r.offset, u.r.record, nil, "data block of %d bytes exceeds its unload record", dataLen)
+		}
+		block := u.record[12 : 12+dataLen]
+		u.record = u.record[12+dataLen:]
+		if blockFlag != 0x00 && blockFlag != 0x80 { //0x80 is end block of unloaded PDSE
+			// Non member data block (notes or extended attributes), ignored
+			continue
+		}
+		if dataLen == 0 {
+			// End of member marker
+			u.inMember = false
+			return nil, io.EOF
+		}
+		return block, nil
+	}
+}
+
+// NextRecord returns the next logical record of the current member, or
+// io.EOF at the end of the member. Variable length records are returned
+// without their RDW.
+func (u *UnloadReader) NextRecord() ([]byte, error) {
+	if err := checkRecfm(u.xmf); err != nil {
+		return nil, err
+	}
+	variableLength := (u.xmf.SourceRecfm[0] == 'V')
+	lrecl := int(u.xmf.SourceLrecl)
+	for len(u.records) == 0 {
+		block, err := u.nextBlock()
+		if err != nil {
+			return nil, err
+		}
+		appendRecord := func(record []byte) error {
+			u.records = append(u.records, record)
+			return nil
+		}
+		if variableLength {
+			err = deblockVariable(block, appendRecord)
+		} else {
+			err = deblockFixed(block, lrecl, log.Warnf, appendRecord)
+		}
+		if err != nil {
+			return nil, locateError(err, u.r.offset, u.r.record)
+		}
+	}
+	record := u.records[0]
+	u.records = u.records[1:]
+	return record, nil
+}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"strings"
+	"time"
 
+	xmit "github.com/jguillaumes/xmit_reader/internal/xmitfile"
 	xu "github.com/jguillaumes/xmit_reader/internal/xmitutils"
 )
 
@@ -28,9 +31,37 @@ func (c *Copyr1) IsPdse() bool {
 	return c.DsFlags&0x01 != 0
 }
 
+// copyr1Eyecatcher is found after the flag byte of the COPYR1 record
+var copyr1Eyecatcher = []byte{0xCA, 0x6D, 0x0F}
+
+// IsUnload tells if data, the start of a file, is the COPYR1 record of an
+// IEBCOPY unload, including its 8 byte record header
+func IsUnload(data []byte) bool {
+	return len(data) >= 12 && bytes.Equal(data[9:12], copyr1Eyecatcher)
+}
+
+// FileParams returns the attributes of the unloaded dataset taken from the
+// COPYR1 record, for unloads that do not come from a XMIT file
+func (c *Copyr1) FileParams() xmit.XmitFileParams {
+	dstype := "PDS"
+	if c.IsPdse() {
+		dstype = "LIBRARY"
+	}
+	return xmit.XmitFileParams{
+		FileNumber:    1,
+		SourceDsorg:   "PO",
+		SourceDstype:  dstype,
+		SourceRecfm:   c.DsRecfm,
+		SourceRecfmHw: xu.RecfmStringToHw(c.DsRecfm),
+		SourceLrecl:   int16(c.DsLrecl),
+		SourceBlksize: int16(c.DsBlkSize),
+		UtilPgmName:   "IEBCOPY",
+	}
+}
+
 func NewCopyr1(raw []byte) (*Copyr1, error) {
 	if len(raw) != Copyr1_size {
-		return nil, fmt.Errorf("invalid Copyr1 record length: expected %d, got %d", Copyr1_size, len(raw))
+		return nil, xmit.NewFormatError(xmit.ErrCorruptDirectory, 0, 1, nil, "invalid COPYR1 record length: expected %d, got %d", Copyr1_size, len(raw))
 	}
 	recordData := bytes.NewBuffer(raw)
 	_ = recordData.Next(8)                                    // Skip the first 8 bytes (header)
@@ -126,7 +157,7 @@ func (c *Copyr2) DebTail() []byte { return c.DebTailS }
 
 func NewCopyr2(raw []byte) (*Copyr2, error) {
 	if len(raw) != Copyr2_size {
-		return nil, fmt.Errorf("invalid Copyr2 record length: expected %d, got %d", Copyr2_size, len(raw))
+		return nil, xmit.NewFormatError(xmit.ErrCorruptDirectory, Copyr1_size, 2, nil, "invalid COPYR2 record length: expected %d, got %d", Copyr2_size, len(raw))
 	}
 	recordData := bytes.NewBuffer(raw)
 	extensions := make([]ExtensionData, 0, 16)
@@ -142,9 +173,9 @@ func NewCopyr2(raw []byte) (*Copyr2, error) {
 		hiEndCylTrk := binary.BigEndian.Uint16(recordData.Next(2))
 		loTracks := binary.BigEndian.Uint16(recordData.Next(2))
 		tracks := uint32(loTracks) + (uint32(hiTracks) << 16)
-		startCyl := uint32(loStartCyl) + uint32((hiStartCylTrk&0xFFF0)<<12)
+		startCyl := cylinderNumber(loStartCyl, hiStartCylTrk)
 		startTrack := uint8(hiStartCylTrk & 0x0F)
-		endCyl := uint32(loEndCyl) + uint32((hiEndCylTrk&0xFFF0)<<12)
+		endCyl := cylinderNumber(loEndCyl, hiEndCylTrk)
 		endTrack := uint8(hiEndCylTrk & 0x0F)
 		extension := ExtensionData{
 			NumTracks:     tracks,
@@ -172,11 +203,126 @@ type MemberEntry struct {
 	MemberName string
 	Track      uint16
 	Offset     uint8
-	FilePtr    int64
+	FilePtr    int64 // Offset of the unload record holding the first data block, or the end-of-member marker of an empty member; 0 if not found
+	FileRecord int   // Number of that unload record, starting at 1
+	FileBlock  int   // Offset of the header of that block in the data of the unload record
+	Aliases    []string
+	Stats      *IspfStats
 }
 
 type MemberMap map[uint32]MemberEntry
 
+// MemberInfo describes a member or an alias of an unloaded PDS. Size is the
+// number of bytes in the member data blocks, including any record descriptor
+// words, so it approximates the size of the member in the original dataset.
+// MissingData tells that no data was found in the unload for the TTR of the
+// directory entry; an empty member has data, just its end-of-member marker.
+type MemberInfo struct {
+	Name        string     `json:"name"`
+	TTR         uint32     `json:"ttr"`
+	Alias       bool       `json:"alias"`
+	AliasOf     string     `json:"alias_of,omitempty"`
+	Size        int64      `json:"size"`
+	MissingData bool       `json:"missing_data,omitempty"`
+	Stats       *IspfStats `json:"ispf_stats,omitempty"`
+}
+
+// Minimum size of the ISPF statistics, extended statistics use 40 bytes
+const IspfStats_size = 30
+const IspfExtStats_size = 40
+
+type IspfStats struct {
+	Version       uint8     `json:"version"`
+	Modification  uint8     `json:"modification"`
+	Sclm          bool      `json:"sclm"`
+	Extended      bool      `json:"extended"`
+	Created       time.Time `json:"created"`
+	Changed       time.Time `json:"changed"`
+	CurrentLines  int       `json:"current_lines"`
+	InitialLines  int       `json:"initial_lines"`
+	ModifiedLines int       `json:"modified_lines"`
+	UserId        string    `json:"userid"`
+}
+
+// NewIspfStats decodes the ISPF statistics kept in the user data of a PDS
+// directory entry
+func NewIspfStats(raw []byte, encoding string) (*IspfStats, error) {
+	if len(raw) < IspfStats_size {
+		return nil, fmt.Errorf("invalid ISPF statistics length: expected at least %d, got %d", IspfStats_size, len(raw))
+	}
+	recordData := bytes.NewBuffer(raw)
+	version, _ := recordData.ReadByte()      // Version number (VV)
+	modification, _ := recordData.ReadByte() // Modification level (MM)
+	flags, _ := recordData.ReadByte()        // Flags: SCLM and extended statistics
+	seconds, err := xu.PackedToInt(recordData.Next(1))
+	if err != nil {
+		return nil, err
+	}
+	created, err := ispfDate(recordData.Next(4)) // Creation date, 0CYYDDDF
+	if err != nil {
+		return nil, err
+	}
+	changed, err := ispfDate(recordData.Next(4)) // Last change date, 0CYYDDDF
+	if err != nil {
+		return nil, err
+	}
+	hhmm, err := xu.PackedToInt(recordData.Next(2)) // Last change time, HHMM
+	if err != nil {
+		return nil, err
+	}
+	changed = changed.Add(time.Duration(hhmm/100)*time.Hour +
+		time.Duration(hhmm%100)*time.Minute + time.Duration(seconds)*time.Second)
+	currentLines := int(binary.BigEndian.Uint16(recordData.Next(2)))
+	initialLines := int(binary.BigEndian.Uint16(recordData.Next(2)))
+	modifiedLines := int(binary.BigEndian.Uint16(recordData.Next(2)))
+	userId, _ := enc.DecodeBytes(recordData.Next(8), encoding)
+
+	extended := flags&0x20 != 0 && len(raw) >= IspfExtStats_size
+	if extended {
+		// Line counts bigger than 65535, in fullwords right after the
+		// user id, which fill the 40 bytes of the extended statistics
+		currentLines = int(binary.BigEndian.Uint32(recordData.Next(4)))
+		initialLines = int(binary.BigEndian.Uint32(recordData.Next(4)))
+		modifiedLines = int(binary.BigEndian.Uint32(recordData.Next(4)))
+	}
+
+	s := &IspfStats{
+		Version:       version,
+		Modification:  modification,
+		Sclm:          flags&0x80 != 0,
+		Extended:      extended,
+		Created:       created,
+		Changed:       changed,
+		CurrentLines:  currentLines,
+		InitialLines:  initialLines,
+		ModifiedLines: modifiedLines,
+		UserId:        strings.TrimRight(userId, " "),
+	}
+	return s, nil
+}
+
+// ispfDate decodes a packed date in the format 0CYYDDDF, where C is the
+// century (0 for 19xx, 1 for 20xx)
+func ispfDate(raw []byte) (time.Time, error) {
+	if len(raw) < 4 {
+		return time.Time{}, fmt.Errorf("invalid packed date %x", raw)
+	}
+	cyyddd, err := xu.PackedToInt(raw[0:3])
+	if err != nil {
+		return time.Time{}, err
+	}
+	lastDigit := int(raw[3] >> 4)
+	if lastDigit > 9 || raw[3]&0x0f != 0x0f {
+		return time.Time{}, fmt.Errorf("invalid packed date %x", raw)
+	}
+	day := (cyyddd%100)*10 + lastDigit
+	year := 1900 + cyyddd/100
+	if day < 1 || day > 366 {
+		return time.Time{}, fmt.Errorf("invalid packed date %x", raw)
+	}
+	return time.Date(year, time.January, day, 0, 0, 0, 0, time.UTC), nil
+}
+
 type ExtensionData struct {
 	NumTracks     uint32 `json:"numtracks"`
 	StartCylinder uint32 `json:"startcylinder"`
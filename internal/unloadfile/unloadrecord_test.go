@@ -0,0 +1,78 @@
+package unloadfile
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// ispfStatsData builds the user data of a directory entry holding ISPF
+// statistics: version 01.05, created on 2024-02-29, changed on 2025-01-01
+// at 12:34:56 by USER01, with the given line counts. With size 40 the
+// extended line counts take the place of the two reserved bytes at the end.
+func ispfStatsData(size int, flags byte, lines [3]int) []byte {
+	raw := []byte{0x01, 0x05, flags, 0x56,
+		0x01, 0x24, 0x06, 0x0f, // 2024, day 060
+		0x01, 0x25, 0x00, 0x1f, // 2025, day 001
+		0x12, 0x34}
+	for _, n := range lines {
+		raw = binary.BigEndian.AppendUint16(raw, uint16(min(n, 0xffff)))
+	}
+	raw = append(raw, 0xe4, 0xe2, 0xc5, 0xd9, 0xf0, 0xf1, 0x40, 0x40) // USER01 in EBCDIC
+	if size == IspfExtStats_size {
+		for _, n := range lines {
+			raw = binary.BigEndian.AppendUint32(raw, uint32(n))
+		}
+	} else {
+		raw = append(raw, 0, 0) // Reserved
+	}
+	return raw
+}
+
+func TestNewIspfStats(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		size     int
+		flags    byte
+		lines    [3]int
+		extended bool
+		want     [3]int
+	}{
+		{"basic", IspfStats_size, 0x00, [3]int{120, 100, 20}, false, [3]int{120, 100, 20}},
+		{"extended flag without room", IspfStats_size, 0x20, [3]int{120, 100, 20}, false, [3]int{120, 100, 20}},
+		{"extended", IspfExtStats_size, 0x20, [3]int{70000, 65536, 123456}, true, [3]int{70000, 65536, 123456}},
+		{"extended size without flag", IspfExtStats_size, 0x00, [3]int{70000, 65536, 123456}, false, [3]int{0xffff, 0xffff, 0xffff}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := ispfStatsData(tc.size, tc.flags, tc.lines)
+			if len(raw) != tc.size {
+				t.Fatalf("test data of %d bytes, want %d", len(raw), tc.size)
+			}
+			s, err := NewIspfStats(raw, "IBM-1047")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Version != 1 || s.Modification != 5 || s.UserId != "USER01" {
+				t.Errorf("got version %02d.%02d by %q", s.Version, s.Modification, s.UserId)
+			}
+			if want := time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC); !s.Created.Equal(want) {
+				t.Errorf("created %s, want %s", s.Created, want)
+			}
+			if want := time.Date(2025, time.January, 1, 12, 34, 56, 0, time.UTC); !s.Changed.Equal(want) {
+				t.Errorf("changed %s, want %s", s.Changed, want)
+			}
+			if s.Extended != tc.extended {
+				t.Errorf("extended %v, want %v", s.Extended, tc.extended)
+			}
+			if got := [3]int{s.CurrentLines, s.InitialLines, s.ModifiedLines}; got != tc.want {
+				t.Errorf("line counts %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewIspfStatsShort(t *testing.T) {
+	if _, err := NewIspfStats(make([]byte, IspfStats_size-1), "IBM-1047"); err == nil {
+		t.Error("no error for short statistics")
+	}
+}
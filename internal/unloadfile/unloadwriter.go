@@ -0,0 +1,292 @@
+package unloadfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	xu "github.com/jguillaumes/xmit_reader/internal/xmitutils"
+)
+
+// The unloaded dataset is laid out as if it had been allocated in a 3380
+// volume, whose geometry is recorded in the COPYR1 DEVTYPE area. IEBCOPY
+// reblocks the members when it loads them into any other device type.
+const (
+	unloadTracksPerCyl = 15
+	unloadTrackCells   = 1499 // 3380 track capacity, in 32 byte cells
+	unloadStartCyl     = 1
+	unloadBlockSize    = 3120 // Block size of the unload (container) dataset
+	maxUnloadRecord    = 32752
+)
+
+var devType3380 = []byte{
+	0x30, 0x30, 0x20, 0x0e, // UCB device type
+	0x00, 0x00, 0x7f, 0xf8, // Maximum block size
+	0x0a, 0x62, // Number of cylinders
+	0x00, 0x0f, // Tracks per cylinder
+	0xbb, 0x60, // Track length
+	0x01, 0x00, 0x20, 0x10, 0x01, 0x0b, // Block overhead and flags
+}
+
+// UnloadMember is a member to be written into an IEBCOPY unload. Its records
+// are logical records without RDW, already converted to EBCDIC.
+type UnloadMember struct {
+	Name    string
+	Records [][]byte
+}
+
+// UnloadParams are the DCB attributes of the unloaded PDS
+type UnloadParams struct {
+	Recfm     string
+	Lrecl     uint16
+	Blksize   uint16
+	DirBlocks int
+	Encoding  string
+}
+
+// blockLayout simulates the placement of blocks on 3380 tracks to compute
+// the CCHHR of each block and the TTR of each member
+type blockLayout struct {
+	track int // Relative track
+	rec   int // Record number in the track
+	cells int // Cells used in the track
+}
+
+func blockCells(dataLen int) int {
+	return 15 + (dataLen+12+31)/32
+}
+
+// next returns the relative track and record number of the next block
+func (l *blockLayout) next(dataLen int) (int, int) {
+	cells := blockCells(dataLen)
+	if l.cells+cells > unloadTrackCells {
+		l.track++
+		l.rec = 0
+		l.cells = 0
+	}
+	l.rec++
+	l.cells += cells
+	return l.track, l.rec
+}
+
+// blockHeader builds the 12 byte header (F MBB CCHHR KL DL) of a data block
+func blockHeader(relTrack int, rec int, dataLen int) []byte {
+	hdr := make([]byte, 12)
+	cyl := unloadStartCyl + relTrack/unloadTracksPerCyl
+	head := relTrack % unloadTracksPerCyl
+	binary.BigEndian.PutUint16(hdr[4:6], uint16(cyl))
+	binary.BigEndian.PutUint16(hdr[6:8], uint16(head))
+	hdr[8] = byte(rec)
+	binary.BigEndian.PutUint16(hdr[10:12], uint16(dataLen))
+	return hdr
+}
+
+// blockRecords groups the logical records of a member into blocks according
+// to the record format
+func blockRecords(records [][]byte, params UnloadParams) ([][]byte, error) {
+	blocks := make([][]byte, 0)
+	variable := len(params.Recfm) > 0 && params.Recfm[0] == 'V'
+	var current *bytes.Buffer
+	for _, r := range records {
+		if variable {
+			if len(r)+4 > int(params.Lrecl) {
+				return nil, fmt.Errorf("record of %d bytes too long for LRECL=%d", len(r), params.Lrecl)
+			}
+			if current == nil || current.Len()+len(r)+4 > int(params.Blksize) {
+				if current != nil {
+					blocks = append(blocks, current.Bytes())
+				}
+				current = bytes.NewBuffer(make([]byte, 4, params.Blksize))
+			}
+			binary.Write(current, binary.BigEndian, uint16(len(r)+4))
+			current.Write([]byte{0, 0})
+			current.Write(r)
+			binary.BigEndian.PutUint16(current.Bytes()[0:2], uint16(current.Len()))
+		} else {
+			if len(r) != int(params.Lrecl) {
+				return nil, fmt.Errorf("record of %d bytes does not match LRECL=%d", len(r), params.Lrecl)
+			}
+			if current == nil || current.Len()+len(r) > int(params.Blksize) {
+				if current != nil {
+					blocks = append(blocks, current.Bytes())
+				}
+				current = bytes.NewBuffer(make([]byte, 0, params.Blksize))
+			}
+			current.Write(r)
+		}
+	}
+	if current != nil {
+		blocks = append(blocks, current.Bytes())
+	}
+	return blocks, nil
+}
+
+// dirEntry is a directory entry of a member to be unloaded
+type dirEntry struct {
+	name []byte
+	ttr  uint32
+}
+
+// buildDirBlocks builds the directory blocks (count, key and data) for the
+// given entries, which must be sorted by name
+func buildDirBlocks(entries []dirEntry) [][]byte {
+	const entryLen = 12 // Name, TTR and C byte, no user data
+	lastEntry := bytes.Repeat([]byte{0xff}, 8)
+	blocks := make([][]byte, 0)
+	var data *bytes.Buffer
+	var key []byte
+	flush := func() {
+		b := bytes.NewBuffer(make([]byte, 0, DirBlock_size))
+		b.Write([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 8, 0x01, 0x00}) // Count: KL=8, DL=256
+		b.Write(key)
+		used := data.Len()
+		binary.BigEndian.PutUint16(data.Bytes()[0:2], uint16(used))
+		b.Write(data.Bytes())
+		b.Write(make([]byte, 256-used))
+		blocks = append(blocks, b.Bytes())
+	}
+	for _, e := range entries {
+		if data == nil || data.Len()+entryLen > 256 {
+			if data != nil {
+				flush()
+			}
+			data = bytes.NewBuffer(make([]byte, 2, 256))
+		}
+		data.Write(e.name)
+		data.Write([]byte{byte(e.ttr >> 16), byte(e.ttr >> 8), byte(e.ttr), 0})
+		key = e.name
+	}
+	// The directory ends with an entry whose name is all 0xff
+	if data == nil || data.Len()+8 > 256 {
+		if data != nil {
+			flush()
+		}
+		data = bytes.NewBuffer(make([]byte, 2, 256))
+	}
+	data.Write(lastEntry)
+	key = lastEntry
+	flush()
+	return blocks
+}
+
+// CreateUnload writes an IEBCOPY unload of a PDS holding the given members.
+// Every unload logical record (without RDW) is passed to writeRecord. It
+// returns the number of directory blocks of the unloaded PDS.
+func CreateUnload(members []UnloadMember, params UnloadParams, writeRecord func([]byte) error) (int, error) {
+	if int(params.Blksize)+12 > maxUnloadRecord {
+		return 0, fmt.Errorf("block size %d too big for an unload file", params.Blksize)
+	}
+
+	// Directory entries must be sorted in EBCDIC order
+	names := make([][]byte, len(members))
+	for i, m := range members {
+		name, err := enc.EncodeString(fmt.Sprintf("%-8s", m.Name), params.Encoding)
+		if err != nil {
+			return 0, err
+		}
+		names[i] = name
+	}
+	order := make([]int, len(members))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return bytes.Compare(names[order[i]], names[order[j]]) < 0 })
+
+	// Directory blocks must fit in the first tracks; a 3380 track holds 46 of them
+	entries := make([]dirEntry, len(members))
+	for i, idx := range order {
+		entries[i].name = names[idx]
+	}
+	dirBlocks := len(buildDirBlocks(entries))
+	layout := blockLayout{track: (max(dirBlocks, params.DirBlocks) + 45) / 46}
+
+	// Block the member data and assign a TTR to each member
+	memberBlocks := make([][][]byte, len(members))
+	memberTtrs := make([][]uint32, len(members))
+	for i, idx := range order {
+		blocks, err := blockRecords(members[idx].Records, params)
+		if err != nil {
+			return 0, fmt.Errorf("member %s: %w", members[idx].Name, err)
+		}
+		memberBlocks[i] = blocks
+		ttrs := make([]uint32, 0, len(blocks)+1)
+		for _, b := range append(blocks, nil) { // The last block is the EOF marker
+			t, r := layout.next(len(b))
+			ttrs = append(ttrs, uint32(t)<<8|uint32(r))
+		}
+		memberTtrs[i] = ttrs
+		entries[i].ttr = ttrs[0]
+	}
+	dirData := buildDirBlocks(entries)
+	totalTracks := layout.track + 1
+	dirBlocks = max(len(dirData), params.DirBlocks)
+
+	// COPYR1
+	c1 := make([]byte, 0, Copyr1_size-8)
+	c1 = append(c1, 0x00, 0xca, 0x6d, 0x0f)
+	c1 = binary.BigEndian.AppendUint16(c1, 0x0200)
+	c1 = binary.BigEndian.AppendUint16(c1, params.Blksize)
+	c1 = binary.BigEndian.AppendUint16(c1, params.Lrecl)
+	c1 = append(c1, xu.RecfmStringToByte(params.Recfm), 0, 0, 0)
+	c1 = binary.BigEndian.AppendUint16(c1, unloadBlockSize)
+	c1 = append(c1, devType3380...)
+	c1 = binary.BigEndian.AppendUint16(c1, 2) // Number of header records
+	now := time.Now()
+	c1 = append(c1, 0, byte(now.Year()-1900))
+	c1 = binary.BigEndian.AppendUint16(c1, uint16(now.YearDay())) // Last reference date
+	c1 = append(c1, 0, 0, 0)                                      // Secondary space extension
+	c1 = append(c1, 0x80, 0, 0, unloadTracksPerCyl)               // Secondary allocation, in tracks
+	var last uint32
+	if len(memberTtrs) > 0 {
+		lastTtrs := memberTtrs[len(memberTtrs)-1]
+		last = lastTtrs[len(lastTtrs)-1]
+	}
+	c1 = append(c1, byte(last>>16), byte(last>>8), byte(last)) // Last used TTR
+	c1 = append(c1, 0, 0, 0, 0)
+	if err := writeRecord(c1); err != nil {
+		return 0, err
+	}
+
+	// COPYR2: DEB basic section tail and a single extent
+	c2 := make([]byte, Copyr2_size-8)
+	copy(c2[0:16], []byte{0x01, 0, 0, 0, 0xff, 0, 0, 0, 0x8f, 0, 0, 0, 0x04, 0, 0, 0})
+	ext := c2[16:32]
+	endTrack := totalTracks - 1
+	binary.BigEndian.PutUint16(ext[6:8], unloadStartCyl)
+	binary.BigEndian.PutUint16(ext[8:10], 0)
+	binary.BigEndian.PutUint16(ext[10:12], uint16(unloadStartCyl+endTrack/unloadTracksPerCyl))
+	binary.BigEndian.PutUint16(ext[12:14], uint16(endTrack%unloadTracksPerCyl))
+	binary.BigEndian.PutUint16(ext[14:16], uint16(totalTracks))
+	ext[5] = byte(totalTracks >> 16)
+	if err := writeRecord(c2); err != nil {
+		return 0, err
+	}
+
+	// Directory blocks, followed by a 12 byte end of directory marker
+	maxDirPerRecord := (maxUnloadRecord - 12) / DirBlock_size
+	for len(dirData) > 0 {
+		n := min(len(dirData), maxDirPerRecord)
+		record := bytes.Join(dirData[:n], nil)
+		dirData = dirData[n:]
+		if len(dirData) == 0 {
+			record = append(record, make([]byte, 12)...)
+		}
+		if err := writeRecord(record); err != nil {
+			return 0, err
+		}
+	}
+
+	// Member data blocks, each member ends with an EOF block
+	for i, blocks := range memberBlocks {
+		ttrs := memberTtrs[i]
+		for j, b := range append(blocks, nil) {
+			record := append(blockHeader(int(ttrs[j]>>8), int(ttrs[j]&0xff), len(b)), b...)
+			if err := writeRecord(record); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return dirBlocks, nil
+}
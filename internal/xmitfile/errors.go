@@ -0,0 +1,90 @@
+package xmitfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Kinds of the errors found in the input data. Every FormatError matches
+// one of them with errors.Is.
+var (
+	ErrTruncated         = errors.New("truncated input")
+	ErrNotXMIT           = errors.New("not a XMIT file")
+	ErrUnsupportedRecfm  = errors.New("unsupported record format")
+	ErrCorruptDirectory  = errors.New("corrupt directory")
+	ErrMissingMemberData = errors.New("missing member data")
+	ErrCorruptData       = errors.New("corrupt data")
+)
+
+// FormatError is an error found in the data of a XMIT file or IEBCOPY
+// unload. Offset is the byte offset where the problem was found, counted
+// from the start of the segment stream for XMIT files (descriptor words
+// excluded, except for the errors in the descriptor words themselves) and
+// from the start of the unload for IEBCOPY unloads, or -1 if the problem is
+// not found at any position, like the missing data of a member. Record is
+// the number of the XMIT segment or unload record, starting at 1, or 0 if it
+// is not known.
+type FormatError struct {
+	Kind   error // One of the Err kinds
+	Offset int64
+	Record int
+	Msg    string
+	Err    error // Underlying error, if any
+}
+
+// NewFormatError builds a FormatError of the given kind. err is the
+// underlying error and can be nil.
+func NewFormatError(kind error, offset int64, record int, err error, format string, args ...any) *FormatError {
+	return &FormatError{
+		Kind:   kind,
+		Offset: offset,
+		Record: record,
+		Msg:    fmt.Sprintf(format, args...),
+		Err:    err,
+	}
+}
+
+// ReadError converts the error returned while reading what into a
+// FormatError of kind ErrTruncated if the input ended too early. Other
+// errors, including FormatErrors, are returned as they are.
+func ReadError(err error, offset int64, record int, what string) error {
+	var formatErr *FormatError
+	if errors.As(err, &formatErr) {
+		return err
+	}
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		return NewFormatError(ErrTruncated, offset, record, io.ErrUnexpectedEOF, "reading %s", what)
+	}
+	return err
+}
+
+func (e *FormatError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Kind.Error())
+	if e.Record > 0 {
+		fmt.Fprintf(&b, " in record %d", e.Record)
+	}
+	if e.Offset >= 0 {
+		fmt.Fprintf(&b, " at offset %d", e.Offset)
+	}
+	if e.Msg != "" {
+		b.WriteString(": ")
+		b.WriteString(e.Msg)
+	}
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+// Is tells if target is the kind of the error
+func (e *FormatError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,150 @@
+package xmitfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// xmitFraming tells how the XMIT segments are stored in the input file.
+// XMIT files are usually downloaded as a plain stream of segments, but
+// transfers of variable length records keep the record descriptor word
+// (RDW) of every record, and sometimes the block descriptor word (BDW) of
+// every block too.
+type xmitFraming int
+
+const (
+	framingUnknown xmitFraming = iota // Not a XMIT file
+	framingNone                       // Plain stream of segments
+	framingRDW                        // Records prefixed by a RDW
+	framingBDW                        // Blocks prefixed by a BDW, holding records prefixed by a RDW
+)
+
+// framingHeadSize is the number of bytes needed by detectFraming
+const framingHeadSize = 16
+
+// detectFraming finds how the segments of a XMIT file are stored from the
+// first bytes of the file, which must start with the INMR01 control record
+func detectFraming(head []byte) xmitFraming {
+	switch {
+	case isINMR01(head):
+		return framingNone
+	case len(head) >= 4 && isDescriptor(head[0:4], 4) && isINMR01(head[4:]):
+		return framingRDW
+	case len(head) >= 8 && isDescriptor(head[0:4], 8) && isDescriptor(head[4:8], 4) && isINMR01(head[8:]):
+		return framingBDW
+	}
+	return framingUnknown
+}
+
+// isINMR01 tells if data starts with the first segment of an INMR01 record
+func isINMR01(data []byte) bool {
+	return len(data) >= 8 && XMITRecordFlags(data[1])&IsControlRecord != 0 && bytes.Equal(data[2:8], inmr01)
+}
+
+// isDescriptor tells if dw is a plausible RDW or BDW: a length of at least
+// minLen followed by two zero bytes
+func isDescriptor(dw []byte, minLen int) bool {
+	return int(binary.BigEndian.Uint16(dw[0:2])) >= minLen && dw[2] == 0 && dw[3] == 0
+}
+
+// descriptorReader returns the data of the records of a variable length
+// file, without their RDW and, if blocked, without the BDW of their blocks
+type descriptorReader struct {
+	r       *bufio.Reader
+	blocked bool
+	block   int   // Bytes left in the current block
+	record  int   // Bytes left in the current record
+	offset  int64 // Offset in the input file of the next byte to be read
+	dw      [4]byte
+}
+
+func newDescriptorReader(r *bufio.Reader, blocked bool) *descriptorReader {
+	return &descriptorReader{r: r, blocked: blocked}
+}
+
+// readDescriptor reads a RDW or BDW and returns its length, which is zero
+// for binary zeros padding the rest of a block or of the file. It returns
+// io.EOF at the end of the file.
+func (d *descriptorReader) readDescriptor(minLen int) (int, error) {
+	if n, err := io.ReadFull(d.r, d.dw[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			if !bytes.Equal(d.dw[:n], make([]byte, n)) {
+				return 0, NewFormatError(ErrTruncated, d.offset, 0, err, "reading descriptor word")
+			}
+			// Trailing padding shorter than a descriptor
+			err = io.EOF
+		}
+		return 0, err
+	}
+	d.offset += 4
+	length := int(binary.BigEndian.Uint16(d.dw[0:2]))
+	if length == 0 && d.dw[2] == 0 && d.dw[3] == 0 {
+		return 0, nil
+	}
+	if length < minLen || d.dw[2] != 0 || d.dw[3] != 0 {
+		return 0, NewFormatError(ErrCorruptData, d.offset-4, 0, nil, "invalid descriptor word %x", d.dw)
+	}
+	return length, nil
+}
+
+func (d *descriptorReader) Read(p []byte) (int, error) {
+	for d.record == 0 {
+		if d.blocked && d.block < 4 {
+			// Skip the padding at the end of the current block
+			if err := d.discard(d.block); err != nil {
+				return 0, err
+			}
+			length, err := d.readDescriptor(8)
+			if err != nil {
+				return 0, err
+			} else if length == 0 {
+				// The rest of the file is padding
+				return 0, io.EOF
+			}
+			d.block = length - 4
+			continue
+		}
+		length, err := d.readDescriptor(4)
+		if err != nil {
+			return 0, err
+		} else if length == 0 {
+			if !d.blocked {
+				// The rest of the file is padding
+				return 0, io.EOF
+			}
+			// The rest of the block is padding
+			d.block -= 4
+			if err := d.discard(d.block); err != nil {
+				return 0, err
+			}
+			d.block = 0
+			continue
+		}
+		if d.blocked {
+			if length > d.block {
+				return 0, NewFormatError(ErrCorruptData, d.offset-4, 0, nil, "record of %d bytes exceeds its block", length)
+			}
+			d.block -= length
+		}
+		d.record = length - 4
+	}
+	if len(p) > d.record {
+		p = p[:d.record]
+	}
+	n, err := d.r.Read(p)
+	d.record -= n
+	d.offset += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// discard skips n bytes of padding
+func (d *descriptorReader) discard(n int) error {
+	discarded, err := d.r.Discard(n)
+	d.offset += int64(discarded)
+	return err
+}
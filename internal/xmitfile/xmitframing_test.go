@@ -0,0 +1,153 @@
+package xmitfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// withRDW splits data into records of at most lrecl bytes, each prefixed by
+// its RDW
+func withRDW(data []byte, lrecl int) [][]byte {
+	var records [][]byte
+	for len(data) > 0 {
+		n := min(lrecl, len(data))
+		record := binary.BigEndian.AppendUint16(nil, uint16(n+4))
+		record = append(record, 0, 0)
+		records = append(records, append(record, data[:n]...))
+		data = data[n:]
+	}
+	return records
+}
+
+// withBDW packs records into blocks of at most blksize bytes, each prefixed
+// by its BDW. With padding, the BDW of every block covers blksize bytes and
+// the space left after its records is filled with binary zeros.
+func withBDW(records [][]byte, blksize int, padding bool) []byte {
+	var out []byte
+	var block []byte
+	flush := func() {
+		length := len(block) + 4
+		if padding {
+			block = append(block, make([]byte, blksize-length)...)
+			length = blksize
+		}
+		out = binary.BigEndian.AppendUint16(out, uint16(length))
+		out = append(append(out, 0, 0), block...)
+		block = block[:0]
+	}
+	for _, r := range records {
+		if len(block)+len(r)+4 > blksize {
+			flush()
+		}
+		block = append(block, r...)
+	}
+	if len(block) > 0 {
+		flush()
+	}
+	return out
+}
+
+// readFramed reads data through the descriptor reader
+func readFramed(data []byte, blocked bool) ([]byte, error) {
+	return io.ReadAll(newDescriptorReader(bufio.NewReader(bytes.NewReader(data)), blocked))
+}
+
+func TestDescriptorReader(t *testing.T) {
+	plain := bytes.Repeat([]byte("0123456789"), 100)
+	records := withRDW(plain, 80)
+	rdw := bytes.Join(records, nil)
+	for _, tc := range []struct {
+		name    string
+		data    []byte
+		blocked bool
+	}{
+		{"RDW", rdw, false},
+		{"RDW with zero padding", append(bytes.Clone(rdw), make([]byte, 100)...), false},
+		{"RDW with short zero tail", append(bytes.Clone(rdw), 0, 0), false},
+		{"BDW", withBDW(records, 340, false), true},
+		{"BDW with padded blocks", withBDW(records, 400, true), true},
+		{"BDW with block padding shorter than a RDW", withBDW(records, 342, true), true},
+		{"BDW with block padding longer than a RDW", withBDW(records, 346, true), true},
+		{"BDW with zero padding", append(withBDW(records, 400, true), make([]byte, 800)...), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readFramed(tc.data, tc.blocked)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Errorf("got %d bytes, want %d", len(got), len(plain))
+			}
+		})
+	}
+}
+
+func TestDescriptorReaderErrors(t *testing.T) {
+	records := withRDW(bytes.Repeat([]byte("0123456789"), 20), 80)
+	rdw := bytes.Join(records, nil)
+	for _, tc := range []struct {
+		name    string
+		data    []byte
+		blocked bool
+		kind    error
+	}{
+		{"truncated RDW", append(bytes.Clone(rdw), 0, 84), false, ErrTruncated},
+		{"truncated record", rdw[:len(rdw)-10], false, io.ErrUnexpectedEOF},
+		{"invalid RDW", append([]byte{0, 3, 0, 0}, rdw...), false, ErrCorruptData},
+		{"RDW with nonzero low halfword", append([]byte{0, 84, 0, 1}, rdw...), false, ErrCorruptData},
+		{"record longer than its block", append([]byte{0, 20, 0, 0}, rdw...), true, ErrCorruptData},
+		{"truncated BDW", append(withBDW(records, 400, false), 1), true, ErrTruncated},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readFramed(tc.data, tc.blocked)
+			if !errors.Is(err, tc.kind) {
+				t.Errorf("got error %v, want %v", err, tc.kind)
+			}
+		})
+	}
+}
+
+// TestFramedSamples checks that the sample XMIT files give the same result
+// when their records keep the RDW, and when they are blocked with BDWs
+func TestFramedSamples(t *testing.T) {
+	log.SetOutput(io.Discard)
+	for name, data := range loadSamples(t) {
+		want, err := unloadOf(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records := withRDW(data, 80)
+		for framing, framed := range map[string][]byte{
+			"RDW":               bytes.Join(records, nil),
+			"BDW":               withBDW(records, 3120, false),
+			"BDW padded blocks": withBDW(records, 3120, true),
+		} {
+			t.Run(name+" "+framing, func(t *testing.T) {
+				if !IsXMIT(framed) {
+					t.Error("framing not recognized")
+				}
+				got, err := unloadOf(framed)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("got %d bytes of data, want %d", len(got), len(want))
+				}
+			})
+		}
+	}
+}
+
+// unloadOf returns the data of all the files of a XMIT file
+func unloadOf(data []byte) ([]byte, error) {
+	var unload bytes.Buffer
+	dataFiles := func(int, *XmitParams) (io.Writer, error) { return &unload, nil }
+	_, err := ProcessXMITFile(bytes.NewReader(data), dataFiles, "IBM-1047")
+	return unload.Bytes(), err
+}
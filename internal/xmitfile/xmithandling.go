@@ -6,196 +6,194 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
-	e "github.com/jguillaumes/go-encoding/encodings"
 	xu "github.com/jguillaumes/xmit_reader/internal/xmitutils"
 )
 
-var enc = e.NewEncoding()
+var enc = xu.SharedEncoding
 
 type XmitFileParams struct {
-	SourceDDName   string    `json:"ddame"`
+	FileNumber     int       `json:"file_number"`
+	IsMessage      bool      `json:"is_message"`
+	SourceDDName   string    `json:"ddname"`
 	SourceDSName   string    `json:"dsname"`
-	SourceDsorg    string    `json:"dssorg"`
-	SourceDstype   string    `json:"dsstype"`
+	SourceDsorg    string    `json:"dsorg"`
+	SourceDstype   string    `json:"dstype"`
 	SourceCreation time.Time `json:"creation"`
 	SourceRecfm    string    `json:"recfm"`
+	SourceRecfmHw  uint16    `json:"recfm_hw"`
 	SourceLrecl    int16     `json:"lrecl"`
 	SourceBlksize  int16     `json:"blksize"`
 	AproxSize      int64     `json:"aprox_size"`
+	SizeMegabytes  int64     `json:"size_mb"`
+	DirBlocks      int       `json:"dir_blocks"`
+	SecondarySpace int64     `json:"secondary_space"`
+	RecordCount    int64     `json:"record_count"`
+	Expiration     time.Time `json:"expiration"`
+	LastChanged    time.Time `json:"last_changed"`
+	LastReferenced time.Time `json:"last_referenced"`
+	Members        []string  `json:"members,omitempty"`
+	ExtendedAttr   string    `json:"extended_attr"`
+	Filemode       string    `json:"filemode"`
 	UtilPgmName    string    `json:"util_pgm_name"`
 }
 
 type XmitParams struct {
-	SourceNodeName string           `json:"source_node_name"`
-	SourceUserId   string           `json:"source_user_id"`
-	SourceTstamp   time.Time        `json:"source_timestamp"`
-	NumFiles       int              `json:"num_files"`
-	XmitFiles      []XmitFileParams `json:"xmit_files"`
+	SourceNodeName string            `json:"source_node_name"`
+	SourceUserId   string            `json:"source_user_id"`
+	SourceTstamp   time.Time         `json:"source_timestamp"`
+	TargetNodeName string            `json:"target_node_name"`
+	TargetUserId   string            `json:"target_user_id"`
+	TargetTstamp   time.Time         `json:"target_timestamp"`
+	XmitLrecl      int               `json:"xmit_lrecl"`
+	FormatVersion  int               `json:"format_version"`
+	AckRequested   bool              `json:"ack_requested"`
+	AckId          string            `json:"ack_id"`
+	UserParameter  string            `json:"user_parameter"`
+	ErrorCode      string            `json:"error_code"`
+	NumFiles       int               `json:"num_files"`
+	XmitFiles      []XmitFileParams  `json:"xmit_files"`
+	FileHeaders    []XmitFileParams  `json:"file_headers"`
+	Notification   *XmitNotification `json:"notification,omitempty"` // INMR07 record, if present
 }
 
+// XmitNotification holds the text units of an INMR07 notification record,
+// sent back by RECEIVE to the origin of a transmission that requested an
+// acknowledgement. The origin and target are those of the notification,
+// not of the original transmission.
+type XmitNotification struct {
+	SourceNodeName string    `json:"source_node_name"`
+	SourceUserId   string    `json:"source_user_id"`
+	SourceTstamp   time.Time `json:"source_timestamp"`
+	TargetNodeName string    `json:"target_node_name"`
+	TargetUserId   string    `json:"target_user_id"`
+	TargetTstamp   time.Time `json:"target_timestamp"`
+	AckId          string    `json:"ack_id"`
+	UserParameter  string    `json:"user_parameter"`
+	ErrorCode      string    `json:"error_code"`
+}
+
+// DataWriterFunc returns the writer that will receive the data records of
+// the transmitted file number fileNumber (starting at 1). xmitParms holds the
+// control records read so far, including the INMR02 records of every file.
+type DataWriterFunc func(fileNumber int, xmitParms *XmitParams) (io.Writer, error)
+
 func NewXmitParams() *XmitParams {
 	return &XmitParams{
 		SourceNodeName: "",
 		SourceUserId:   "",
 		NumFiles:       0,
 		XmitFiles:      make([]XmitFileParams, 0),
+		FileHeaders:    make([]XmitFileParams, 0),
+	}
+}
+
+// File returns the attributes of the original dataset of the transmitted file
+// number fileNumber, taken from its first INMR02 record. When a file has been
+// processed by more than one utility (e.g. IEBCOPY and INMCOPY) the first
+// INMR02 describes the dataset as it was before being transmitted.
+func (x *XmitParams) File(fileNumber int) (XmitFileParams, bool) {
+	for _, f := range x.XmitFiles {
+		if f.FileNumber == fileNumber {
+			return f, true
+		}
 	}
+	return XmitFileParams{}, false
 }
 
-func ProcessXMITFile(inFile io.Reader, targetDir string, unloadFile io.Writer, encoding string) (*XmitParams, error) {
+// ProcessXMITFile reads the XMIT control records and reassembles the data
+// records of every transmitted file, which are written to the writer returned
+// by dataFiles for that file. The input is read through a buffer, so it may be
+// read past the INMR06 record that ends the XMIT file.
+func ProcessXMITFile(inFile io.Reader, dataFiles DataWriterFunc, encoding string) (*XmitParams, error) {
 
 	count := 0
 	xmitParms := *NewXmitParams()
 	var endOfXmit bool = false
-	var currentBlock *bytes.Buffer
-	var foundINMR01 = false
+	var currentFile io.Writer
+
+	// The segments of a data block are reassembled after room for the 8 byte
+	// header of the unload record, so the whole record is written at once.
+	// The buffer is reused for every block
+	var currentBlock bytes.Buffer
+	var blockHeader [8]byte
+	inBlock := false
 
+	records := newXMITRecordReader(inFile)
 	for !endOfXmit {
-		data, err := readXMITRecord(inFile)
-		if err != nil {
+		offset := records.offset
+		data, err := records.next()
+		if err == io.EOF {
+			if count == 0 {
+				return nil, NewFormatError(ErrNotXMIT, offset, 0, nil, "empty input")
+			}
+			return nil, NewFormatError(ErrTruncated, offset, records.count, io.ErrUnexpectedEOF, "INMR06 record not found")
+		} else if err != nil {
+			if count == 0 {
+				// Whatever the problem with the first record, this is not a XMIT file
+				return nil, NewFormatError(ErrNotXMIT, offset, 1, nil, "invalid first record: %v", err)
+			}
 			return nil, err
 		}
-		log.Debugf("Record Length: %3d, flags: %08b, id: %s\n", data.recordLen(), data.recordFlags(), data.recordId())
-		switch data.recordId() {
+		recordId := data.recordId()
+		if count == 0 && (data.recordFlags()&IsControlRecord == 0 || recordId != "INMR01") {
+			return nil, NewFormatError(ErrNotXMIT, offset, records.count, nil, "the first record is not an INMR01 control record")
+		}
+		if log.IsLevelEnabled(log.DebugLevel) {
+			log.Debugf("Record Length: %3d, flags: %08b, id: %s\n", data.recordLen(), data.recordFlags(), recordId)
+		}
+		switch recordId {
 		case "INMR01":
-			foundINMR01 = true
-			tus := data.textUnits(0)
-			for t := range tus {
-				tu := tus[t]
-				switch tu.Id() {
-				case XtuINMNUMF:
-					xtud := tu.Data()[0]
-					nb := xtud.Len
-					xmitParms.NumFiles = xu.GetVariableLengthInt(int(nb), xtud.Data)
-				case XtuINMFUID:
-					userId, _ := enc.DecodeBytes(tu.Data()[0].Data, encoding)
-					xmitParms.SourceUserId = userId
-				case XtuINMFNODE:
-					nodeName, _ := enc.DecodeBytes(tu.Data()[0].Data, encoding)
-					xmitParms.SourceNodeName = nodeName
-				case XtuINMFTIME:
-					tuDv := tu.Data()[0]
-					// The timestamp is in the format YYYYMMDDHHMSS in EBCDIC
-					tstamp, _ := enc.DecodeBytes(tuDv.Data, encoding)
-					// Parse the timestamp
-					timestamp, err := time.Parse("20060102150405", tstamp)
-					if err != nil {
-						log.Warnf("Error parsing timestamp: %v\n", err)
-					} else {
-						xmitParms.SourceTstamp = timestamp
-					}
-				}
-			}
+			decodeXmitTextUnits(data.textUnits(0), &xmitParms, encoding)
 		case "INMR02":
-			var fileParams XmitFileParams
-			_ = binary.BigEndian.Uint32(data.recordData()[6:10])
-			tus := data.textUnits(4)
-			for t := range tus {
-				tu := tus[t]
-				switch tu.Id() {
-				case XtuINMUTILN:
-					utilPgmName, _ := enc.DecodeBytes(tu.Data()[0].Data, "IBM-1047")
-					fileParams.UtilPgmName = utilPgmName
-				case XtuINMDSORG:
-					dsorgBytes := xu.GetVariableLengthInt(2, tu.Data()[0].Data)
-					switch dsorgBytes {
-					case 0x0008:
-						fileParams.SourceDsorg = "VSAM"
-					case 0x0200:
-						fileParams.SourceDsorg = "PO"
-						fileParams.SourceDstype = "PDS"
-					case 0x4000:
-						fileParams.SourceDsorg = "PS"
-					default:
-						fileParams.SourceDsorg = "UNKNOWN"
-					}
-				case XtuINMTYPE:
-					tuDv := tu.Data()[0]
-					dstyteByte := tuDv.Data[0]
-					switch dstyteByte {
-					case 0x80:
-						fileParams.SourceDstype = "LIBRARY"
-					case 0x40:
-						fileParams.SourceDstype = "PGMLIB"
-					case 0x04:
-						fileParams.SourceDstype = "EXTENDED"
-					case 0x01:
-						fileParams.SourceDstype = "LARGE"
-					}
-				case XtuINMRECFM:
-					tuDv := tu.Data()[0]
-					recfmBytes := xu.GetVariableLengthInt(int(tuDv.Len), tuDv.Data)
-					fileParams.SourceRecfm = xu.RecfmHwToString(uint16(recfmBytes))
-				case XtuINMCREAT:
-					tuDv := tu.Data()[0]
-					// The creation date is in the format YYYYMMDD in EBCDIC
-					creationDate, _ := enc.DecodeBytes(tuDv.Data, "IBM-1047")
-					// Parse the creation date
-					creation, _ := time.Parse("20060102", creationDate)
-					fileParams.SourceCreation = creation
-				case XtuINMLRECL:
-					tuDv := tu.Data()[0]
-					lreclBytes := xu.GetVariableLengthInt(int(tuDv.Len), tuDv.Data)
-					fileParams.SourceLrecl = int16(lreclBytes)
-				case XtuINMBLKSZ:
-					tuDv := tu.Data()[0]
-					blksizeBytes := xu.GetVariableLengthInt(int(tuDv.Len), tuDv.Data)
-					fileParams.SourceBlksize = int16(blksizeBytes)
-				case XtuINMSIZE:
-					tuDv := tu.Data()[0]
-					aproxSizeBytes := xu.GetVariableLengthInt(int(tuDv.Len), tuDv.Data)
-					fileParams.AproxSize = int64(aproxSizeBytes)
-				case XtuINMDDNAM:
-					ddname, _ := enc.DecodeBytes(tu.Data()[0].Data, encoding)
-					fileParams.SourceDDName = ddname
-				case XtuINMDSNAM:
-					parts := tus[t].Count()
-					var dsname string
-					for i := uint16(0); i < parts; i++ {
-						partData := tus[t].Data()[i]
-						partName, _ := enc.DecodeBytes(partData.Data, encoding)
-						dsname += partName
-						if i < parts-1 {
-							dsname += "."
-						}
-					}
-					fileParams.SourceDSName = dsname
-				default:
-					log.Tracef("Unknown text unit ID: %04x\n", tu.Id())
-				}
+			if len(data.recordData()) < 10 {
+				return nil, NewFormatError(ErrCorruptData, offset, records.count, nil, "INMR02 record too short: %d bytes", len(data.recordData()))
 			}
+			var fileParams XmitFileParams
+			fileParams.FileNumber = int(binary.BigEndian.Uint32(data.recordData()[6:10]))
+			decodeFileTextUnits(data.textUnits(4), &fileParams, encoding)
 			xmitParms.XmitFiles = append(xmitParms.XmitFiles, fileParams)
 		case "INMR03":
-			// File header record, ignore it
+			// File header record, the data records that follow belong to a new file
+			var headerParams XmitFileParams
+			headerParams.FileNumber = len(xmitParms.FileHeaders) + 1
+			decodeFileTextUnits(data.textUnits(0), &headerParams, encoding)
+			xmitParms.FileHeaders = append(xmitParms.FileHeaders, headerParams)
+			currentFile, err = dataFiles(headerParams.FileNumber, &xmitParms)
+			if err != nil {
+				return nil, err
+			}
 		case "INMR04":
 			// User control record, ignore it
 		case "INMR06": // Last record in the XMIT file, end processing here
 			log.Debugln("End of XMIT file processing.")
 			endOfXmit = true
 		case "INMR07":
-			// Notification record, ignore it
+			// Notification record, sent back by RECEIVE when requested
+			xmitParms.Notification = &XmitNotification{}
+			decodeNotificationTextUnits(data.textUnits(0), xmitParms.Notification, encoding)
 		default:
-			// If we have not found an INMR01 this is not an XMIT file
-			if !foundINMR01 {
-				return nil, fmt.Errorf("this does not look like an XMIT file")
-			}
 			// Data reecord
-			if data.recordFlags()&FirstSegment != 0 {
-				currentBlock = bytes.NewBuffer(make([]byte, 0, 32767))
+			if currentFile == nil {
+				return nil, NewFormatError(ErrCorruptData, offset, records.count, nil, "data record found before any INMR03 file header")
+			}
+			if data.recordFlags()&FirstSegment != 0 || !inBlock {
+				currentBlock.Reset()
+				currentBlock.Write(blockHeader[:])
+				inBlock = true
 			}
 			currentBlock.Write(data.recordData())
 			if data.recordFlags()&LastSegment != 0 {
-				blockLen := int16(currentBlock.Len()) + 8
-				lenBytes := make([]byte, 8)
-				binary.BigEndian.PutUint16(lenBytes, uint16(blockLen))
-				binary.BigEndian.PutUint16(lenBytes[2:], uint16(0))
-				binary.BigEndian.PutUint32(lenBytes[4:], uint32(0))
-				unloadFile.Write(lenBytes)
-				unloadFile.Write(currentBlock.Bytes())
+				block := currentBlock.Bytes()
+				binary.BigEndian.PutUint16(block[0:2], uint16(len(block)))
+				if _, err := currentFile.Write(block); err != nil {
+					return nil, err
+				}
+				inBlock = false
 			}
 		}
 		count++
@@ -211,3 +209,221 @@ func ProcessXMITFile(inFile io.Reader, targetDir string, unloadFile io.Writer, e
 
 	return &xmitParms, nil
 }
+
+// decodeFileTextUnits stores the text units of an INMR02 or INMR03 control
+// record into fileParams
+func decodeFileTextUnits(tus []XmitTextUnit, fileParams *XmitFileParams, encoding string) {
+	for t := range tus {
+		tu := tus[t]
+		switch tu.Id() {
+		case XtuINMUTILN:
+			fileParams.UtilPgmName = decodeString(tu, "IBM-1047")
+		case XtuINMDSORG:
+			dsorgBytes := xu.GetVariableLengthInt(2, firstValue(tu))
+			switch dsorgBytes {
+			case 0x0008:
+				fileParams.SourceDsorg = "VSAM"
+			case 0x0200:
+				fileParams.SourceDsorg = "PO"
+				fileParams.SourceDstype = "PDS"
+			case 0x4000:
+				fileParams.SourceDsorg = "PS"
+			default:
+				fileParams.SourceDsorg = "UNKNOWN"
+			}
+		case XtuINMTYPE:
+			var dstyteByte byte
+			if value := firstValue(tu); len(value) > 0 {
+				dstyteByte = value[0]
+			}
+			switch dstyteByte {
+			case 0x80:
+				fileParams.SourceDstype = "LIBRARY"
+			case 0x40:
+				fileParams.SourceDstype = "PGMLIB"
+			case 0x04:
+				fileParams.SourceDstype = "EXTENDED"
+			case 0x01:
+				fileParams.SourceDstype = "LARGE"
+			}
+		case XtuINMRECFM:
+			recfmBytes := decodeInt(tu)
+			fileParams.SourceRecfm = xu.RecfmHwToString(uint16(recfmBytes))
+			fileParams.SourceRecfmHw = uint16(recfmBytes)
+		case XtuINMCREAT:
+			fileParams.SourceCreation = decodeTimestamp(tu, encoding)
+		case XtuINMLCHG:
+			fileParams.LastChanged = decodeTimestamp(tu, encoding)
+		case XtuINMLREF:
+			fileParams.LastReferenced = decodeTimestamp(tu, encoding)
+		case XtuINMEXPDT:
+			fileParams.Expiration = decodeTimestamp(tu, encoding)
+		case XtuINMLSIZE:
+			fileParams.SizeMegabytes = decodeInt(tu)
+		case XtuINMSECND:
+			fileParams.SecondarySpace = decodeInt(tu)
+		case XtuINMRECCT:
+			fileParams.RecordCount = decodeInt(tu)
+		case XtuINMMEMBR:
+			for _, d := range tu.Data() {
+				member, _ := enc.DecodeBytes(d.Data, encoding)
+				fileParams.Members = append(fileParams.Members, member)
+			}
+		case XtuINMEATTR:
+			switch decodeInt(tu) {
+			case 0x01:
+				fileParams.ExtendedAttr = "NO"
+			case 0x02:
+				fileParams.ExtendedAttr = "OPT"
+			}
+		case XtuINMFFM:
+			fileParams.Filemode = decodeString(tu, encoding)
+		case XtuINMLRECL:
+			fileParams.SourceLrecl = int16(decodeInt(tu))
+		case XtuINMBLKSZ:
+			fileParams.SourceBlksize = int16(decodeInt(tu))
+		case XtuINMSIZE:
+			fileParams.AproxSize = decodeInt(tu)
+		case XtuINMTERM:
+			fileParams.IsMessage = true
+		case XtuINMDIR:
+			fileParams.DirBlocks = int(decodeInt(tu))
+		case XtuINMDDNAM:
+			fileParams.SourceDDName = decodeString(tu, encoding)
+		case XtuINMDSNAM:
+			parts := make([]string, 0, tu.Count())
+			for _, partData := range tu.Data() {
+				partName, _ := enc.DecodeBytes(partData.Data, encoding)
+				parts = append(parts, partName)
+			}
+			fileParams.SourceDSName = strings.Join(parts, ".")
+		default:
+			log.Tracef("Unknown text unit ID: %s\n", tu.Id())
+		}
+	}
+}
+
+// decodeXmitTextUnits stores the text units of an INMR01 control record
+// into xmitParms
+func decodeXmitTextUnits(tus []XmitTextUnit, xmitParms *XmitParams, encoding string) {
+	for t := range tus {
+		tu := tus[t]
+		switch tu.Id() {
+		case XtuINMNUMF:
+			xmitParms.NumFiles = int(decodeInt(tu))
+		case XtuINMLRECL:
+			xmitParms.XmitLrecl = int(decodeInt(tu))
+		case XtuINMFUID:
+			xmitParms.SourceUserId = decodeString(tu, encoding)
+		case XtuINMFNODE:
+			xmitParms.SourceNodeName = decodeString(tu, encoding)
+		case XtuINMTUID:
+			xmitParms.TargetUserId = decodeString(tu, encoding)
+		case XtuINMTNODE:
+			xmitParms.TargetNodeName = decodeString(tu, encoding)
+		case XtuINMFTIME:
+			xmitParms.SourceTstamp = decodeTimestamp(tu, encoding)
+		case XtuINMTTIME:
+			xmitParms.TargetTstamp = decodeTimestamp(tu, encoding)
+		case XtuINMFVERS:
+			xmitParms.FormatVersion = int(decodeInt(tu))
+		case XtuINMFACK:
+			xmitParms.AckRequested = true
+			xmitParms.AckId = decodeString(tu, encoding)
+		case XtuINMUSERP:
+			xmitParms.UserParameter = decodeString(tu, encoding)
+		case XtuINMERRCD:
+			xmitParms.ErrorCode = decodeString(tu, encoding)
+		default:
+			log.Tracef("Unknown text unit ID: %s\n", tu.Id())
+		}
+	}
+}
+
+// decodeNotificationTextUnits stores the text units of an INMR07 control
+// record into notification
+func decodeNotificationTextUnits(tus []XmitTextUnit, notification *XmitNotification, encoding string) {
+	for _, tu := range tus {
+		switch tu.Id() {
+		case XtuINMFUID:
+			notification.SourceUserId = decodeString(tu, encoding)
+		case XtuINMFNODE:
+			notification.SourceNodeName = decodeString(tu, encoding)
+		case XtuINMTUID:
+			notification.TargetUserId = decodeString(tu, encoding)
+		case XtuINMTNODE:
+			notification.TargetNodeName = decodeString(tu, encoding)
+		case XtuINMFTIME:
+			notification.SourceTstamp = decodeTimestamp(tu, encoding)
+		case XtuINMTTIME:
+			notification.TargetTstamp = decodeTimestamp(tu, encoding)
+		case XtuINMFACK:
+			notification.AckId = decodeString(tu, encoding)
+		case XtuINMUSERP:
+			notification.UserParameter = decodeString(tu, encoding)
+		case XtuINMERRCD:
+			notification.ErrorCode = decodeString(tu, encoding)
+		default:
+			log.Tracef("Unknown text unit ID: %s\n", tu.Id())
+		}
+	}
+}
+
+// firstValue returns the first value of a text unit, or nil if it has none
+func firstValue(tu XmitTextUnit) []byte {
+	if len(tu.Data()) == 0 {
+		return nil
+	}
+	return tu.Data()[0].Data
+}
+
+// decodeInt returns the first value of a text unit as a big endian integer
+func decodeInt(tu XmitTextUnit) int64 {
+	value := firstValue(tu)
+	return int64(xu.GetVariableLengthInt(len(value), value))
+}
+
+// decodeString returns the first value of a text unit as a string
+func decodeString(tu XmitTextUnit, encoding string) string {
+	value, _ := enc.DecodeBytes(firstValue(tu), encoding)
+	return value
+}
+
+// decodeTimestamp parses the first value of a text unit as a date or a
+// timestamp. Dates are in the format YYYYMMDD or YYYYDDD (julian) and
+// timestamps in the format YYYYMMDDHHMMSS, optionally followed by fractions
+// of a second.
+func decodeTimestamp(tu XmitTextUnit, encoding string) time.Time {
+	value := decodeString(tu, encoding)
+	if value == "" {
+		return time.Time{}
+	}
+	timestamp, err := parseXmitTimestamp(value)
+	if err != nil {
+		log.Warnf("Error parsing timestamp %q in text unit %s: %v\n", value, tu.Id(), err)
+	}
+	return timestamp
+}
+
+func parseXmitTimestamp(value string) (time.Time, error) {
+	switch {
+	case len(value) == 7:
+		return time.Parse("2006002", value)
+	case len(value) == 8:
+		return time.Parse("20060102", value)
+	case len(value) == 12:
+		return time.Parse("200601021504", value)
+	case len(value) >= 14:
+		timestamp, err := time.Parse("20060102150405", value[:14])
+		if err != nil || len(value) == 14 {
+			return timestamp, err
+		}
+		fraction, err := time.ParseDuration("0." + value[14:] + "s")
+		if err != nil {
+			return timestamp, err
+		}
+		return timestamp.Add(fraction), nil
+	default:
+		return time.Time{}, fmt.Errorf("unexpected timestamp length %d", len(value))
+	}
+}
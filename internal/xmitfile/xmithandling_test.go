@@ -0,0 +1,109 @@
+package xmitfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// addSamples seeds the corpus of a fuzz target with the sample XMIT files,
+// and with every one of them cut in the middle
+func addSamples(f *testing.F) {
+	log.SetOutput(io.Discard)
+	for _, data := range loadSamples(f) {
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+	}
+}
+
+// checkError fails if a parser returns an error that is not an I/O error
+// or a FormatError
+func checkError(t *testing.T, err error) {
+	var formatErr *FormatError
+	if err != nil && !errors.As(err, &formatErr) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("unexpected error type %T: %v", err, err)
+	}
+}
+
+func FuzzProcessXMITFile(f *testing.F) {
+	addSamples(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var unload bytes.Buffer
+		dataFiles := func(int, *XmitParams) (io.Writer, error) { return &unload, nil }
+		_, err := ProcessXMITFile(bytes.NewReader(data), dataFiles, "IBM-1047")
+		checkError(t, err)
+	})
+}
+
+func FuzzInspectXMITFile(f *testing.F) {
+	addSamples(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, err := InspectXMITFile(bytes.NewReader(data), "IBM-1047")
+		checkError(t, err)
+	})
+}
+
+func FuzzTextUnits(f *testing.F) {
+	log.SetOutput(io.Discard)
+	f.Add([]byte{0x00, 0x02, 0x00, 0x02, 0x00, 0x03, 0xC1, 0xC2, 0xC3, 0x00, 0x01, 0xC4})
+	f.Add([]byte{0x10, 0x2F, 0x00, 0x01, 0x00, 0x04, 0x00, 0x00, 0x00, 0x01})
+	f.Add([]byte{0x00, 0x30, 0xFF, 0xFF, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		record := XMITRecordImpl{
+			recordLenValue:   byte(min(len(data)+8, 255)),
+			recordFlagsValue: IsControlRecord | FirstSegment | LastSegment,
+			recordDataValue:  append(append([]byte{}, inmr01...), data...),
+		}
+		var xmitParms XmitParams
+		var fileParams XmitFileParams
+		decodeXmitTextUnits(record.textUnits(0), &xmitParms, "IBM-1047")
+		decodeFileTextUnits(record.textUnits(0), &fileParams, "IBM-1047")
+		for _, tu := range record.textUnits(0) {
+			inspectTextUnit(tu, "IBM-1047")
+		}
+	})
+}
+
+// TestNotificationRecord checks that the text units of an INMR07 record do
+// not overwrite those of the INMR01 record
+func TestNotificationRecord(t *testing.T) {
+	control := func(xw *XMITWriter, id string, values map[XmitTextUnitId]string) {
+		t.Helper()
+		tus := make([]XmitTextUnit, 0, len(values))
+		for _, tuId := range []XmitTextUnitId{XtuINMFNODE, XtuINMFUID, XtuINMFTIME, XtuINMFACK} {
+			if value, ok := values[tuId]; ok {
+				tu, err := stringTextUnit(tuId, value, "IBM-1047")
+				if err != nil {
+					t.Fatal(err)
+				}
+				tus = append(tus, tu)
+			}
+		}
+		if err := xw.WriteControlRecord(id, nil, tus); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var xmit bytes.Buffer
+	xw := NewXMITWriter(&xmit)
+	control(xw, "INMR01", map[XmitTextUnitId]string{XtuINMFNODE: "NODEA", XtuINMFUID: "USERA", XtuINMFTIME: "20250102030405"})
+	control(xw, "INMR07", map[XmitTextUnitId]string{XtuINMFNODE: "NODEB", XtuINMFUID: "USERB", XtuINMFTIME: "20250607080910", XtuINMFACK: "ACK1"})
+	control(xw, "INMR06", nil)
+
+	xmitParms, err := ProcessXMITFile(&xmit, nil, "IBM-1047")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xmitParms.SourceNodeName != "NODEA" || xmitParms.SourceUserId != "USERA" || xmitParms.SourceTstamp.Month() != 1 {
+		t.Errorf("INMR01 fields overwritten: %s %s %s", xmitParms.SourceNodeName, xmitParms.SourceUserId, xmitParms.SourceTstamp)
+	}
+	n := xmitParms.Notification
+	if n == nil {
+		t.Fatal("INMR07 record not decoded")
+	}
+	if n.SourceNodeName != "NODEB" || n.SourceUserId != "USERB" || n.SourceTstamp.Month() != 6 || n.AckId != "ACK1" {
+		t.Errorf("wrong notification fields: %+v", *n)
+	}
+}
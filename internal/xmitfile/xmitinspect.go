@@ -0,0 +1,140 @@
+package xmitfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	xu "github.com/jguillaumes/xmit_reader/internal/xmitutils"
+)
+
+// Inspection is the low level description of a XMIT file: its control
+// records and the data segments that follow every INMR03 record.
+type Inspection struct {
+	ControlSegments int                `json:"control_segments"`
+	DataSegments    int                `json:"data_segments"`
+	Records         []InspectedControl `json:"control_records"`
+}
+
+// InspectedControl is a control record of a XMIT file
+type InspectedControl struct {
+	Offset     int64               `json:"offset"` // Offset in the segment stream, descriptor words excluded
+	Id         string              `json:"id"`
+	Length     int                 `json:"length"`
+	Flags      []string            `json:"flags"`
+	FileNumber int                 `json:"file_number,omitempty"` // INMR02 and INMR03 only
+	TextUnits  []InspectedTextUnit `json:"text_units,omitempty"`
+	Data       *InspectedData      `json:"data,omitempty"` // INMR03 only
+}
+
+// InspectedTextUnit is a text unit of a control record. Numeric values are
+// shown in decimal and character values decoded from EBCDIC.
+type InspectedTextUnit struct {
+	Key    uint16   `json:"key"`
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+	Hex    []string `json:"hex"`
+}
+
+// InspectedData counts the data segments of a transmitted file, and the
+// records reassembled from them
+type InspectedData struct {
+	Segments int   `json:"segments"`
+	Records  int   `json:"records"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// InspectXMITFile reads a XMIT file and describes its control records and
+// data segments, without decoding the transmitted data.
+func InspectXMITFile(inFile io.Reader, encoding string) (*Inspection, error) {
+	inspection := &Inspection{Records: make([]InspectedControl, 0)}
+	records := newXMITRecordReader(inFile)
+	var data *InspectedData
+	numFiles := 0
+
+	for {
+		offset := records.offset
+		record, err := records.next()
+		if err == io.EOF && len(inspection.Records) > 0 {
+			return inspection, nil
+		} else if err == io.EOF {
+			return nil, NewFormatError(ErrNotXMIT, offset, 0, nil, "empty input")
+		} else if err != nil && len(inspection.Records) == 0 {
+			return nil, NewFormatError(ErrNotXMIT, offset, 1, nil, "invalid first record: %v", err)
+		} else if err != nil {
+			return nil, err
+		}
+
+		flags := record.recordFlags()
+		if flags&IsControlRecord == 0 {
+			if len(inspection.Records) == 0 {
+				return nil, NewFormatError(ErrNotXMIT, offset, records.count, nil, "the first record is not a control record")
+			}
+			inspection.DataSegments++
+			if data == nil {
+				// Data segments before any INMR03, counted only in the total
+				continue
+			}
+			data.Segments++
+			data.Bytes += int64(len(record.recordData()))
+			if flags&LastSegment != 0 {
+				data.Records++
+			}
+			continue
+		}
+
+		inspection.ControlSegments++
+		id := record.recordId()
+		control := InspectedControl{
+			Offset: offset,
+			Id:     id,
+			Length: int(record.recordLen()),
+			Flags:  flags.Names(),
+		}
+		prefix := uint8(0)
+		switch id {
+		case "INMR02":
+			if len(record.recordData()) >= 10 {
+				control.FileNumber = int(binary.BigEndian.Uint32(record.recordData()[6:10]))
+			}
+			prefix = 4
+		case "INMR03":
+			numFiles++
+			control.FileNumber = numFiles
+			control.Data = &InspectedData{}
+		}
+		data = control.Data
+		if len(record.recordData()) >= 6+int(prefix) {
+			for _, tu := range record.textUnits(prefix) {
+				control.TextUnits = append(control.TextUnits, inspectTextUnit(tu, encoding))
+			}
+		}
+		inspection.Records = append(inspection.Records, control)
+		if id == "INMR06" {
+			return inspection, nil
+		}
+	}
+}
+
+// inspectTextUnit decodes the values of a text unit
+func inspectTextUnit(tu XmitTextUnit, encoding string) InspectedTextUnit {
+	itu := InspectedTextUnit{
+		Key:    uint16(tu.Id()),
+		Name:   tu.Id().String(),
+		Values: make([]string, 0, tu.Count()),
+		Hex:    make([]string, 0, tu.Count()),
+	}
+	for _, v := range tu.Data() {
+		itu.Hex = append(itu.Hex, fmt.Sprintf("%X", v.Data))
+		if tu.Id().IsNumeric() && len(v.Data) <= 8 {
+			itu.Values = append(itu.Values, fmt.Sprintf("%d", xu.GetVariableLengthInt(len(v.Data), v.Data)))
+			continue
+		}
+		value, err := enc.DecodeBytes(v.Data, encoding)
+		if err != nil {
+			value = ""
+		}
+		itu.Values = append(itu.Values, value)
+	}
+	return itu
+}
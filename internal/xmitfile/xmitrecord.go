@@ -1,7 +1,10 @@
 package xmitfile
 
 import (
+	"bufio"
+	"fmt"
 	"io"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -15,6 +18,40 @@ const (
 	IsRecordNumber  XMITRecordFlags = 0x10
 )
 
+// Names returns the names of the flags that are set
+func (f XMITRecordFlags) Names() []string {
+	names := make([]string, 0, 4)
+	if f&FirstSegment != 0 {
+		names = append(names, "FirstSegment")
+	}
+	if f&LastSegment != 0 {
+		names = append(names, "LastSegment")
+	}
+	if f&IsControlRecord != 0 {
+		names = append(names, "IsControlRecord")
+	}
+	if f&IsRecordNumber != 0 {
+		names = append(names, "IsRecordNumber")
+	}
+	return names
+}
+
+func (f XMITRecordFlags) String() string {
+	return strings.Join(f.Names(), ",")
+}
+
+// inmr01 is the identifier of the control record that starts a XMIT file,
+// in EBCDIC
+var inmr01 = []byte{0xC9, 0xD5, 0xD4, 0xD9, 0xF0, 0xF1}
+
+// IsXMIT tells if data, the start of a file, is the INMR01 control record
+// that begins a XMIT file, either as a plain stream of segments or prefixed
+// by record and block descriptor words. At least 16 bytes are needed to
+// recognize all of them.
+func IsXMIT(data []byte) bool {
+	return detectFraming(data) != framingUnknown
+}
+
 type XMITRecord interface {
 	recordLen() byte
 	recordFlags() XMITRecordFlags
@@ -65,45 +102,82 @@ func (x *XMITRecordImpl) textUnits(offset uint8) []XmitTextUnit {
 	var textUnits []XmitTextUnit
 
 	// Make a slice past the first 6 bytes of the data
-	data := x.recordDataValue[6+offset:]
-	len := len(data)
-	for len > 0 {
+	if len(x.recordDataValue) < 6+int(offset) {
+		return nil
+	}
+	data := x.recordDataValue[6+int(offset):]
+	for len(data) > 0 {
 		tu, numbytes := newXmitTextUnit(data)
-		len -= numbytes
+		if tu == nil {
+			// A truncated text unit ends the record
+			log.Debugf("Ignoring %d bytes of invalid text unit data\n", len(data))
+			break
+		}
 		textUnits = append(textUnits, tu)
 		data = data[numbytes:]
 	}
 	return textUnits
 }
 
-// readXMITRecord reads a single XMIT record from the provided bufio.Reader.
-func readXMITRecord(f io.Reader) (XMITRecord, error) {
-	oneByte := make([]byte, 1)
+// xmitBufferSize is the size of the input buffer of the record reader
+const xmitBufferSize = 64 * 1024
+
+// xmitRecordReader reads XMIT segments from a buffered input. The segment
+// data is read into a buffer owned by the reader, so a record returned by
+// next is only valid until the following call.
+type xmitRecordReader struct {
+	r      *bufio.Reader
+	offset int64 // Offset of the next segment, descriptor words excluded
+	count  int   // Number of segments read
+	header [2]byte
+	data   [253]byte // A segment is at most 255 bytes, length and flags included
+	record XMITRecordImpl
+}
 
-	_, err := f.Read(oneByte)
-	if err != nil {
-		return nil, err
+// newXMITRecordReader creates a record reader for f. Record and block
+// descriptor words around the segments are removed. Padding after the end of
+// the XMIT file is never read, as reading stops at the INMR06 record.
+func newXMITRecordReader(f io.Reader) *xmitRecordReader {
+	r := bufio.NewReaderSize(f, xmitBufferSize)
+	head, _ := r.Peek(framingHeadSize)
+	switch detectFraming(head) {
+	case framingRDW:
+		log.Infoln("The XMIT records are prefixed by RDWs, removing them")
+		r = bufio.NewReaderSize(newDescriptorReader(r, false), xmitBufferSize)
+	case framingBDW:
+		log.Infoln("The XMIT records are prefixed by BDWs and RDWs, removing them")
+		r = bufio.NewReaderSize(newDescriptorReader(r, true), xmitBufferSize)
 	}
-	recordLen := oneByte[0]
+	return &xmitRecordReader{r: r}
+}
 
-	_, err = f.Read(oneByte)
-	if err != nil {
+// next reads the next XMIT segment. It returns io.EOF at the end of the
+// input, and a FormatError if the segment is truncated or invalid.
+func (x *xmitRecordReader) next() (XMITRecord, error) {
+	offset := x.offset
+	if _, err := io.ReadFull(x.r, x.header[:]); err == io.EOF {
 		return nil, err
+	} else if err != nil {
+		return nil, ReadError(err, offset, x.count+1, "segment header")
+	}
+	x.count++
+	recordLen := x.header[0]
+	x.offset += int64(recordLen)
+	if recordLen < 2 {
+		return nil, NewFormatError(ErrCorruptData, offset, x.count, nil, "invalid XMIT segment length %d", recordLen)
 	}
-	recordFlags := oneByte[0]
 
-	// Read the record data
-	data := make([]byte, recordLen-2) // -2 for the length and flags bytes
-	if l, err := f.Read(data); err != nil {
-		return nil, err
-	} else if l < int(recordLen-2) {
-		log.Errorf("Expected to read %d bytes, but got %d bytes\n", recordLen-2, l)
-		return nil, io.ErrUnexpectedEOF
+	// Read the record data. Streams may return less data than requested in
+	// a single read, so keep reading until the whole segment is available
+	data := x.data[:recordLen-2] // -2 for the length and flags bytes
+	if _, err := io.ReadFull(x.r, data); err != nil {
+		return nil, ReadError(err, offset, x.count, fmt.Sprintf("segment of %d bytes", recordLen))
 	}
 
-	return &XMITRecordImpl{
+	x.record = XMITRecordImpl{
 		recordLenValue:   recordLen,
-		recordFlagsValue: XMITRecordFlags(recordFlags),
+		recordFlagsValue: XMITRecordFlags(x.header[1]),
 		recordDataValue:  data,
-	}, nil
+	}
+	return &x.record, nil
 }
@@ -0,0 +1,68 @@
+package xmitfile
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadSamples reads the sample XMIT files in the data directory
+func loadSamples(tb testing.TB) map[string][]byte {
+	names, err := filepath.Glob(filepath.Join("..", "..", "data", "*.xmit"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if len(names) == 0 {
+		tb.Skip("no sample XMIT files found")
+	}
+	samples := make(map[string][]byte, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		samples[filepath.Base(name)] = data
+	}
+	return samples
+}
+
+// BenchmarkReadXMITRecord measures the throughput of the segment reader
+func BenchmarkReadXMITRecord(b *testing.B) {
+	for name, data := range loadSamples(b) {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				rr := newXMITRecordReader(bytes.NewReader(data))
+				for {
+					record, err := rr.next()
+					if err != nil {
+						break
+					}
+					if record.recordId() == "INMR06" {
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkProcessXMITFile measures the throughput of the reassembly of the
+// data records of a XMIT file
+func BenchmarkProcessXMITFile(b *testing.B) {
+	discard := func(int, *XmitParams) (io.Writer, error) { return io.Discard, nil }
+	for name, data := range loadSamples(b) {
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := ProcessXMITFile(bytes.NewReader(data), discard, "IBM-1047"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
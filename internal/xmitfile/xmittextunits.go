@@ -3,6 +3,7 @@ package xmitfile
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 )
 
 type XmitTextUnitId uint16
@@ -42,6 +43,73 @@ const (
 	XtuINMUTILN XmitTextUnitId = 0x1028 // Name of utility program
 )
 
+// textUnitNames are the mnemonics of the known text units
+var textUnitNames = map[XmitTextUnitId]string{
+	XtuINMBLKSZ: "INMBLKSZ",
+	XtuINMCREAT: "INMCREAT",
+	XtuINMDDNAM: "INMDDNAM",
+	XtuINMDIR:   "INMDIR",
+	XtuINMDSNAM: "INMDSNAM",
+	XtuINMDSORG: "INMDSORG",
+	XtuINMEATTR: "INMEATTR",
+	XtuINMERRCD: "INMERRCD",
+	XtuINMEXPDT: "INMEXPDT",
+	XtuINMFACK:  "INMFACK",
+	XtuINMFFM:   "INMFFM",
+	XtuINMFNODE: "INMFNODE",
+	XtuINMFTIME: "INMFTIME",
+	XtuINMFUID:  "INMFUID",
+	XtuINMFVERS: "INMFVERS",
+	XtuINMLCHG:  "INMLCHG",
+	XtuINMLRECL: "INMLRECL",
+	XtuINMLREF:  "INMLREF",
+	XtuINMLSIZE: "INMLSIZE",
+	XtuINMMEMBR: "INMMEMBR",
+	XtuINMNUMF:  "INMNUMF",
+	XtuINMRECCT: "INMRECCT",
+	XtuINMRECFM: "INMRECFM",
+	XtuINMSECND: "INMSECND",
+	XtuINMSIZE:  "INMSIZE",
+	XtuINMTERM:  "INMTERM",
+	XtuINMTNODE: "INMTNODE",
+	XtuINMTTIME: "INMTTIME",
+	XtuINMTUID:  "INMTUID",
+	XtuINMTYPE:  "INMTYPE",
+	XtuINMUSERP: "INMUSERP",
+	XtuINMUTILN: "INMUTILN",
+}
+
+// numericTextUnits are the text units whose values are binary numbers
+var numericTextUnits = map[XmitTextUnitId]bool{
+	XtuINMBLKSZ: true,
+	XtuINMDIR:   true,
+	XtuINMDSORG: true,
+	XtuINMEATTR: true,
+	XtuINMFVERS: true,
+	XtuINMLRECL: true,
+	XtuINMLSIZE: true,
+	XtuINMNUMF:  true,
+	XtuINMRECCT: true,
+	XtuINMRECFM: true,
+	XtuINMSECND: true,
+	XtuINMSIZE:  true,
+	XtuINMTYPE:  true,
+}
+
+// String returns the mnemonic of the text unit, or its hexadecimal value if
+// it is not known
+func (id XmitTextUnitId) String() string {
+	if name, ok := textUnitNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("%04X", uint16(id))
+}
+
+// IsNumeric tells if the values of the text unit are binary numbers
+func (id XmitTextUnitId) IsNumeric() bool {
+	return numericTextUnits[id]
+}
+
 type XmitTextUnitData struct {
 	Len  uint16
 	Data []byte
@@ -70,6 +138,9 @@ func newXmitTextUnit(raw []byte) (XmitTextUnit, int) {
 	id := XmitTextUnitId(binary.BigEndian.Uint16(buf.Next(2)))
 	// Do the same for the count, which is the next 2 bytes.
 	count := binary.BigEndian.Uint16(buf.Next(2))
+	if int(count) > buf.Len()/2 {
+		return nil, 0 // Not enough data for the length of every value
+	}
 	// Allocate a slice of XmitTextUnitData with the size of the count
 	data := make([]XmitTextUnitData, count)
 	numbytes += 4 // 2 bytes for ID and 2 bytes for count
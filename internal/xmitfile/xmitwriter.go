@@ -0,0 +1,260 @@
+package xmitfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	xu "github.com/jguillaumes/xmit_reader/internal/xmitutils"
+)
+
+// Maximum length of a XMIT segment, including the length and flags bytes
+const maxSegmentLen = 255
+
+// Logical record length of a XMIT dataset
+const xmitLrecl = 80
+
+// DataSourceFunc writes, using writeRecord, the data records of the
+// transmitted file number fileNumber (starting at 1)
+type DataSourceFunc func(fileNumber int, writeRecord func([]byte) error) error
+
+// XMITWriter writes XMIT records, splitting them into segments. The output
+// is padded with EBCDIC blanks to a multiple of the XMIT record length.
+type XMITWriter struct {
+	w       io.Writer
+	written int64
+}
+
+func NewXMITWriter(w io.Writer) *XMITWriter {
+	return &XMITWriter{w: w}
+}
+
+// WriteControlRecord writes an INMRxx control record. The prefix bytes are
+// written between the record identifier and the text units (for instance,
+// the file number of an INMR02 record).
+func (x *XMITWriter) WriteControlRecord(id string, prefix []byte, tus []XmitTextUnit) error {
+	idBytes, err := enc.EncodeString(id, "IBM-1047")
+	if err != nil {
+		return err
+	}
+	record := bytes.NewBuffer(idBytes)
+	record.Write(prefix)
+	for _, tu := range tus {
+		record.Write(encodeTextUnit(tu))
+	}
+	return x.writeSegments(record.Bytes(), IsControlRecord)
+}
+
+// WriteDataRecord writes a data record of the transmitted file
+func (x *XMITWriter) WriteDataRecord(data []byte) error {
+	return x.writeSegments(data, 0)
+}
+
+// Close pads the output up to the XMIT record length. It does not close the
+// underlying writer.
+func (x *XMITWriter) Close() error {
+	rest := x.written % xmitLrecl
+	if rest == 0 {
+		return nil
+	}
+	n, err := x.w.Write(bytes.Repeat([]byte{0x40}, int(xmitLrecl-rest)))
+	x.written += int64(n)
+	return err
+}
+
+func (x *XMITWriter) writeSegments(data []byte, flags XMITRecordFlags) error {
+	segment := make([]byte, 0, maxSegmentLen)
+	first := true
+	for first || len(data) > 0 {
+		n := min(len(data), maxSegmentLen-2)
+		segFlags := flags
+		if first {
+			segFlags |= FirstSegment
+		}
+		if n == len(data) {
+			segFlags |= LastSegment
+		}
+		segment = append(segment[:0], byte(n+2), byte(segFlags))
+		segment = append(segment, data[:n]...)
+		written, err := x.w.Write(segment)
+		x.written += int64(written)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+		first = false
+	}
+	return nil
+}
+
+// NewXmitTextUnit builds a text unit with the given values
+func NewXmitTextUnit(id XmitTextUnitId, values ...[]byte) XmitTextUnit {
+	data := make([]XmitTextUnitData, len(values))
+	for i, v := range values {
+		data[i] = XmitTextUnitData{Len: uint16(len(v)), Data: v}
+	}
+	return &XmitTextUnitImpl{
+		IdValue:    id,
+		CountValue: uint16(len(values)),
+		DataValue:  data,
+	}
+}
+
+func encodeTextUnit(tu XmitTextUnit) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 64))
+	binary.Write(buf, binary.BigEndian, uint16(tu.Id()))
+	binary.Write(buf, binary.BigEndian, tu.Count())
+	for _, d := range tu.Data() {
+		binary.Write(buf, binary.BigEndian, d.Len)
+		buf.Write(d.Data)
+	}
+	return buf.Bytes()
+}
+
+// intTextUnit builds a text unit holding a big endian integer of size bytes
+func intTextUnit(id XmitTextUnitId, value int64, size int) XmitTextUnit {
+	data := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		data[i] = byte(value)
+		value >>= 8
+	}
+	return NewXmitTextUnit(id, data)
+}
+
+// stringTextUnit builds a text unit holding an EBCDIC string
+func stringTextUnit(id XmitTextUnitId, value string, encoding string) (XmitTextUnit, error) {
+	data, err := enc.EncodeString(value, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return NewXmitTextUnit(id, data), nil
+}
+
+// WriteXMITFile writes a complete XMIT file. The INMR01 record is built from
+// xmitParms, which must have an INMR02 entry in XmitFiles for every file.
+// The data records of each file are provided by data.
+func WriteXMITFile(out io.Writer, xmitParms *XmitParams, data DataSourceFunc, encoding string) error {
+	xw := NewXMITWriter(out)
+
+	tstamp := xmitParms.SourceTstamp
+	if tstamp.IsZero() {
+		tstamp = time.Now()
+	}
+	inmr01 := []XmitTextUnit{intTextUnit(XtuINMLRECL, xmitLrecl, 1)}
+	for _, s := range []struct {
+		id    XmitTextUnitId
+		value string
+	}{
+		{XtuINMFNODE, xmitParms.SourceNodeName},
+		{XtuINMFUID, xmitParms.SourceUserId},
+		{XtuINMTNODE, xmitParms.TargetNodeName},
+		{XtuINMTUID, xmitParms.TargetUserId},
+		{XtuINMFTIME, tstamp.Format("20060102150405")},
+	} {
+		tu, err := stringTextUnit(s.id, strings.ToUpper(s.value), encoding)
+		if err != nil {
+			return err
+		}
+		inmr01 = append(inmr01, tu)
+	}
+	inmr01 = append(inmr01, intTextUnit(XtuINMNUMF, int64(xmitParms.NumFiles), 1))
+	if err := xw.WriteControlRecord("INMR01", nil, inmr01); err != nil {
+		return err
+	}
+
+	for _, f := range xmitParms.XmitFiles {
+		tus, err := encodeFileTextUnits(f, encoding)
+		if err != nil {
+			return err
+		}
+		fileNumber := binary.BigEndian.AppendUint32(nil, uint32(f.FileNumber))
+		if err := xw.WriteControlRecord("INMR02", fileNumber, tus); err != nil {
+			return err
+		}
+	}
+
+	for n := 1; n <= xmitParms.NumFiles; n++ {
+		header := []XmitTextUnit{
+			intTextUnit(XtuINMDSORG, 0x4000, 2),
+			intTextUnit(XtuINMLRECL, xmitLrecl, 2),
+			intTextUnit(XtuINMRECFM, 0x0001, 2), // Shortened VBS format transmission records
+		}
+		if err := xw.WriteControlRecord("INMR03", nil, header); err != nil {
+			return err
+		}
+		if err := data(n, xw.WriteDataRecord); err != nil {
+			return err
+		}
+	}
+
+	if err := xw.WriteControlRecord("INMR06", nil, nil); err != nil {
+		return err
+	}
+	return xw.Close()
+}
+
+// encodeFileTextUnits builds the text units of an INMR02 record from fileParams
+func encodeFileTextUnits(fileParams XmitFileParams, encoding string) ([]XmitTextUnit, error) {
+	tus := make([]XmitTextUnit, 0, 10)
+	if fileParams.UtilPgmName != "" {
+		tu, err := stringTextUnit(XtuINMUTILN, fileParams.UtilPgmName, "IBM-1047")
+		if err != nil {
+			return nil, err
+		}
+		tus = append(tus, tu)
+	}
+	if fileParams.AproxSize > 0 {
+		tus = append(tus, intTextUnit(XtuINMSIZE, fileParams.AproxSize, 4))
+	}
+	switch fileParams.SourceDsorg {
+	case "PO":
+		tus = append(tus, intTextUnit(XtuINMDSORG, 0x0200, 2))
+	case "PS":
+		tus = append(tus, intTextUnit(XtuINMDSORG, 0x4000, 2))
+	case "VSAM":
+		tus = append(tus, intTextUnit(XtuINMDSORG, 0x0008, 2))
+	default:
+		return nil, fmt.Errorf("unsupported DSORG %q", fileParams.SourceDsorg)
+	}
+	switch fileParams.SourceDstype {
+	case "LIBRARY":
+		tus = append(tus, intTextUnit(XtuINMTYPE, 0x80, 1))
+	case "PGMLIB":
+		tus = append(tus, intTextUnit(XtuINMTYPE, 0x40, 1))
+	case "EXTENDED":
+		tus = append(tus, intTextUnit(XtuINMTYPE, 0x04, 1))
+	case "LARGE":
+		tus = append(tus, intTextUnit(XtuINMTYPE, 0x01, 1))
+	}
+	tus = append(tus, intTextUnit(XtuINMLRECL, int64(fileParams.SourceLrecl), 4))
+	if fileParams.SourceBlksize > 0 {
+		tus = append(tus, intTextUnit(XtuINMBLKSZ, int64(fileParams.SourceBlksize), 4))
+	}
+	recfm := fileParams.SourceRecfmHw
+	if recfm == 0 {
+		recfm = xu.RecfmStringToHw(fileParams.SourceRecfm)
+	}
+	tus = append(tus, intTextUnit(XtuINMRECFM, int64(recfm), 2))
+	if fileParams.DirBlocks > 0 {
+		tus = append(tus, intTextUnit(XtuINMDIR, int64(fileParams.DirBlocks), 3))
+	}
+	if fileParams.IsMessage {
+		tus = append(tus, NewXmitTextUnit(XtuINMTERM))
+	}
+	if fileParams.SourceDSName != "" {
+		qualifiers := strings.Split(strings.ToUpper(fileParams.SourceDSName), ".")
+		values := make([][]byte, len(qualifiers))
+		for i, q := range qualifiers {
+			v, err := enc.EncodeString(q, encoding)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		tus = append(tus, NewXmitTextUnit(XtuINMDSNAM, values...))
+	}
+	return tus, nil
+}
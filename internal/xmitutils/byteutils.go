@@ -1,14 +1,29 @@
 package xmitutils
 
-// getVariableLenghInt reads a variable-length integer from the provided byte slice.
-// The number of bytes to read is specified by numbytes.
-// It returns the integer value and an error if the data is insufficient.
-// If the data is less than numbytes, it returns io.ErrShortBuffer.
+import "fmt"
+
+// GetVariableLengthInt reads a big endian integer of numbytes bytes from the
+// provided byte slice. If the slice is shorter than numbytes only the bytes
+// available are read.
 func GetVariableLengthInt(numbytes int, data []byte) int {
 
 	var value int
-	for i := range int(numbytes) {
+	for i := range min(numbytes, len(data)) {
 		value = (value << 8) | int(data[i])
 	}
 	return value
 }
+
+// PackedToInt decodes unsigned packed decimal digits (two digits per byte,
+// no sign nibble). It returns an error if any nibble is not a decimal digit.
+func PackedToInt(data []byte) (int, error) {
+	var value int
+	for _, b := range data {
+		hi, lo := int(b>>4), int(b&0x0f)
+		if hi > 9 || lo > 9 {
+			return 0, fmt.Errorf("invalid packed decimal data %x", data)
+		}
+		value = value*100 + hi*10 + lo
+	}
+	return value, nil
+}
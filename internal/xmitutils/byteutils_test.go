@@ -0,0 +1,26 @@
+package xmitutils
+
+import "testing"
+
+func FuzzGetVariableLengthInt(f *testing.F) {
+	f.Add(4, []byte{0x00, 0x00, 0x01, 0x00})
+	f.Add(8, []byte{0x01, 0x02})
+	f.Add(-1, []byte{})
+	f.Fuzz(func(t *testing.T, numbytes int, data []byte) {
+		value := GetVariableLengthInt(numbytes, data)
+		if numbytes <= 0 && value != 0 {
+			t.Fatalf("got %d reading %d bytes", value, numbytes)
+		}
+	})
+}
+
+func FuzzPackedToInt(f *testing.F) {
+	f.Add([]byte{0x01, 0x23})
+	f.Add([]byte{0x1f})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, err := PackedToInt(data)
+		if err == nil && value < 0 && len(data) <= 9 {
+			t.Fatalf("negative value %d decoding %x", value, data)
+		}
+	})
+}
@@ -56,3 +56,49 @@ func RecfmByteToString(recfmByte byte) string {
 	}
 	return dcbfmt
 }
+
+func RecfmStringToHw(recfm string) uint16 {
+	var recfmBytes uint16
+	for _, c := range recfm {
+		switch c {
+		case 'F':
+			recfmBytes |= 0x8000
+		case 'V':
+			recfmBytes |= 0x4000
+		case 'U':
+			recfmBytes |= 0xC000
+		case 'B':
+			recfmBytes |= 0x1000
+		case 'S':
+			recfmBytes |= 0x0800
+		case 'A':
+			recfmBytes |= 0x0400
+		case 'M':
+			recfmBytes |= 0x0200
+		}
+	}
+	return recfmBytes
+}
+
+func RecfmStringToByte(recfm string) byte {
+	var recfmByte byte
+	for _, c := range recfm {
+		switch c {
+		case 'F':
+			recfmByte |= 0x80
+		case 'V':
+			recfmByte |= 0x40
+		case 'U':
+			recfmByte |= 0xC0
+		case 'B':
+			recfmByte |= 0x10 // Blocked records
+		case 'S':
+			recfmByte |= 0x08 // Spanned records
+		case 'A':
+			recfmByte |= 0x04 // ASA control characters
+		case 'C', 'M':
+			recfmByte |= 0x02 // Machine control characters
+		}
+	}
+	return recfmByte
+}
@@ -0,0 +1,72 @@
+package xmitutils
+
+import (
+	"strings"
+	"sync"
+
+	e "github.com/jguillaumes/go-encoding/encodings"
+	log "github.com/sirupsen/logrus"
+)
+
+// Encoding converts between EBCDIC code pages and Unicode. The go-encoding
+// tables are built the first time a code page is used and kept in a cache
+// that is not safe for concurrent use, so the cache is guarded by a mutex.
+// Once built a table is only read, and conversions run without holding the
+// lock. It is safe for concurrent use by multiple goroutines.
+type Encoding struct {
+	mu       sync.Mutex
+	encoding e.Encoding
+}
+
+// SharedEncoding is the Encoding used by all the packages, so every table is
+// built only once per process
+var SharedEncoding = &Encoding{encoding: e.NewEncoding()}
+
+// GetDecodingTableFor returns the table converting the bytes of a code page
+// to runes, building it if needed
+func (enc *Encoding) GetDecodingTableFor(code string) (*e.DecodingTable, error) {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	return enc.encoding.GetDecodingTableFor(code)
+}
+
+// GetEncodingMapFor returns the map converting runes to the bytes of a code
+// page, building it if needed
+func (enc *Encoding) GetEncodingMapFor(code string) (*e.EncodingMap, error) {
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+	return enc.encoding.GetEncodingMapFor(code)
+}
+
+// DecodeBytes converts bytes of a code page to a string
+func (enc *Encoding) DecodeBytes(bs []byte, code string) (string, error) {
+	decoder, err := enc.GetDecodingTableFor(code)
+	if err != nil {
+		return "", err
+	}
+	var builder strings.Builder
+	builder.Grow(len(bs))
+	for _, b := range bs {
+		builder.WriteRune((*decoder)[b])
+	}
+	return builder.String(), nil
+}
+
+// EncodeString converts a string to bytes of a code page. Runes without
+// encoding are converted to blanks.
+func (enc *Encoding) EncodeString(s string, code string) ([]byte, error) {
+	encoder, err := enc.GetEncodingMapFor(code)
+	if err != nil {
+		return nil, err
+	}
+	encoded := make([]byte, 0, len(s))
+	for _, r := range s {
+		b, ok := (*encoder)[r]
+		if !ok {
+			log.Warnf("No encoding for the rune %v in %s, set to an encoded space\n", r, code)
+			b = (*encoder)[' ']
+		}
+		encoded = append(encoded, b)
+	}
+	return encoded, nil
+}
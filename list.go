@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jguillaumes/xmit_reader/internal/unloadfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitfile"
+)
+
+// listedFile is the description of a transmitted file shown by the list mode
+type listedFile struct {
+	Entry      string                  `json:"entry,omitempty"`
+	FileNumber int                     `json:"file_number"`
+	IsMessage  bool                    `json:"is_message"`
+	DSName     string                  `json:"dsname"`
+	Dsorg      string                  `json:"dsorg"`
+	Recfm      string                  `json:"recfm"`
+	Lrecl      int16                   `json:"lrecl"`
+	Blksize    int16                   `json:"blksize"`
+	Records    int                     `json:"records,omitempty"`
+	Size       int64                   `json:"size,omitempty"`
+	Members    []unloadfile.MemberInfo `json:"members,omitempty"`
+}
+
+// listMain implements the list mode: it shows the members of the transmitted
+// files without extracting them. Everything is kept in memory, nothing is
+// written to disk.
+func listMain(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	inputFile := fs.String("input", "", "Input XMIT file or IEBCOPY unload to be listed, optionally in a zip or gzip archive")
+	entryFlag := fs.String("entry", "", "Name or pattern of the entries to be listed when the input is a zip archive. The default is every XMIT file in the archive")
+	jsonFlag := fs.Bool("json", false, "Write the list in JSON format")
+	encoding := fs.String("encoding", "IBM-1047", "EBCDIC encoding used in the original files. The default is IBM-1047")
+	debugFlag := fs.Bool("debug", false, "Output debug information (maybe quite verbose)")
+
+	fs.Parse(args)
+
+	if *debugFlag {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	if *inputFile == "" {
+		fs.Usage()
+		return 16
+	}
+
+	entries, inFile, err := openInput(*inputFile, *entryFlag)
+	if err != nil {
+		log.Error("Error opening input file: ", err.Error())
+		return 8
+	}
+	defer inFile.Close()
+
+	rc := 0
+	files := make([]listedFile, 0, len(entries))
+	for _, entry := range entries {
+		entryFiles, err := listEntry(entry, *encoding)
+		files = append(files, entryFiles...)
+		if err != nil {
+			if entry.name != "" {
+				log.Errorf("Archive entry %s: %v\n", entry.name, err)
+			} else {
+				log.Errorln(err)
+			}
+			rc = 8
+		}
+	}
+
+	if *jsonFlag {
+		marshalled, err := json.MarshalIndent(files, "", "  ")
+		if err != nil {
+			log.Errorln(err)
+			return 8
+		}
+		fmt.Println(string(marshalled))
+	} else {
+		printFileList(os.Stdout, files)
+	}
+	return rc
+}
+
+// listEntry returns the description of the transmitted files of a XMIT file
+// or IEBCOPY unload found in the input file
+func listEntry(entry inputEntry, encoding string) ([]listedFile, error) {
+	in, err := entry.open()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	// The unload of every transmitted file is kept in memory
+	buffers := make([]*bytes.Buffer, 0, 1)
+	newBuffer := func(fileNumber int, _ *xmitfile.XmitParams) (io.Writer, error) {
+		b := new(bytes.Buffer)
+		buffers = append(buffers, b)
+		return b, nil
+	}
+	xmitParms, err := readInput(in, newBuffer, encoding)
+	if err != nil {
+		return nil, fmt.Errorf("error processing input file: %w", err)
+	}
+
+	var errs []error
+	files := make([]listedFile, 0, len(buffers))
+	for i, b := range buffers {
+		fileNumber := i + 1
+		xmf, ok := xmitParms.File(fileNumber)
+		if !ok {
+			xmf = xmitParms.FileHeaders[i]
+		}
+		lf := listedFile{
+			Entry:      entry.name,
+			FileNumber: fileNumber,
+			IsMessage:  xmf.IsMessage,
+			DSName:     xmf.SourceDSName,
+			Dsorg:      xmf.SourceDsorg,
+			Recfm:      xmf.SourceRecfm,
+			Lrecl:      xmf.SourceLrecl,
+			Blksize:    xmf.SourceBlksize,
+		}
+		switch xmf.SourceDsorg {
+		case "PS":
+			lf.Records, lf.Size = countRecords(b.Bytes())
+		default:
+			lf.Members, err = unloadfile.ListUnloadFile(bytes.NewReader(b.Bytes()), encoding)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("file %d: %w", fileNumber, err))
+			}
+		}
+		files = append(files, lf)
+	}
+	return files, errors.Join(errs...)
+}
+
+// countRecords returns the number of records and data bytes of a sequential
+// file unload
+func countRecords(data []byte) (int, int64) {
+	records := 0
+	var size int64
+	for len(data) >= 8 {
+		recLen := int(binary.BigEndian.Uint16(data[0:2]))
+		if recLen < 8 || recLen > len(data) {
+			break
+		}
+		records++
+		size += int64(recLen - 8)
+		data = data[recLen:]
+	}
+	return records, size
+}
+
+// printFileList writes the list of transmitted files and their members as
+// a table
+func printFileList(out io.Writer, files []listedFile) {
+	for _, f := range files {
+		name := f.DSName
+		if f.IsMessage {
+			name = "(message)"
+		}
+		if f.Entry != "" {
+			fmt.Fprintf(out, "%s ", f.Entry)
+		}
+		fmt.Fprintf(out, "File %d: %s DSORG=%s RECFM=%s LRECL=%d BLKSIZE=%d\n",
+			f.FileNumber, name, f.Dsorg, f.Recfm, f.Lrecl, f.Blksize)
+		if f.Dsorg == "PS" {
+			fmt.Fprintf(out, "  %d records, %d bytes\n\n", f.Records, f.Size)
+			continue
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  Name\tTTR\tAlias of\tSize\tVV.MM\tCreated\tChanged\tLines\tUserid")
+		for _, m := range f.Members {
+			if m.MissingData {
+				fmt.Fprintf(tw, "  %s\t%06X\t%s\tmissing", m.Name, m.TTR, m.AliasOf)
+			} else {
+				fmt.Fprintf(tw, "  %s\t%06X\t%s\t%d", m.Name, m.TTR, m.AliasOf, m.Size)
+			}
+			if s := m.Stats; s != nil {
+				fmt.Fprintf(tw, "\t%02d.%02d\t%s\t%s\t%d\t%s\n", s.Version, s.Modification,
+					s.Created.Format("2006-01-02"), s.Changed.Format("2006-01-02 15:04:05"), s.CurrentLines, s.UserId)
+			} else {
+				fmt.Fprintln(tw, "\t\t\t\t\t")
+			}
+		}
+		tw.Flush()
+		fmt.Fprintf(out, "  %d entries\n\n", len(f.Members))
+	}
+}
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/jguillaumes/xmit_reader/internal/seqfile"
 	"github.com/jguillaumes/xmit_reader/internal/unloadfile"
 	"github.com/jguillaumes/xmit_reader/internal/xmitfile"
 )
@@ -23,13 +31,33 @@ func main() {
 
 	log.SetFormatter(&logf)
 
-	inputFile := flag.String("input", "", "Input XMIT file to be processed")
+	// Modes other than extraction are selected by the first argument
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "create":
+			os.Exit(createMain(os.Args[2:]))
+		case "list":
+			os.Exit(listMain(os.Args[2:]))
+		case "inspect":
+			os.Exit(inspectMain(os.Args[2:]))
+		}
+	}
+
+	inputFile := flag.String("input", "", "Input XMIT file or IEBCOPY unload to be processed, optionally in a zip or gzip archive")
 	targetDir := flag.String("target", "", "Path to the output directory")
 	typeExt := flag.String("type", "", "File type (to be used as extension)")
-	unloadFile := flag.String("unload", "", "Name of the IEBCOPY unload file. If not specified it will be not kept and a temporary file will be used")
+	unloadFile := flag.String("unload", "", "Name of the IEBCOPY unload file to be kept. If not specified the unload is only kept in memory")
 	debugFlag := flag.Bool("debug", false, "Output debug information (maybe quite verbose)")
 	encoding := flag.String("encoding", "IBM-1047", "EBCDIC encoding used in the original files. The default is IBM-1047")
 	traceFlag := flag.Bool("trace", false, "Maximum debug output. VERY verbose")
+	aliasFlag := flag.String("alias", "copy", "How to write member aliases: copy, symlink, hardlink or list (not written)")
+	includeFlag := flag.String("include", "", "Comma separated list of member masks (ABC*, A%C) or re:<regexp> patterns to be extracted. The default is all members")
+	excludeFlag := flag.String("exclude", "", "Comma separated list of member masks or re:<regexp> patterns not to be extracted")
+	membersFile := flag.String("members", "", "File holding the member masks or patterns to be extracted, one per line")
+	entryFlag := flag.String("entry", "", "Name or pattern of the entries to be processed when the input is a zip archive. The default is every XMIT file in the archive")
+	nestedFlag := flag.Int("nested", 0, "Maximum depth of the XMIT files and IEBCOPY unloads found in members or sequential datasets to be unpacked into subdirectories. With 0 they are written as binary files")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "Number of members extracted concurrently")
+	manifestFile := flag.String("manifest", "", "Name of the JSON manifest describing the extracted files to be written. If not specified no manifest is written")
 
 	flag.Parse()
 
@@ -42,96 +70,373 @@ func main() {
 		log.SetReportCaller(true)
 	}
 
-	var deleteUnloadFile bool = true
-
 	// Check if input file, target directory and file type are provided
 	if *inputFile == "" || *targetDir == "" || *typeExt == "" {
 		flag.Usage()
 		os.Exit(16)
 	}
 
+	aliasPolicy, err := unloadfile.ParseAliasPolicy(*aliasFlag)
+	if err != nil {
+		log.Errorln(err)
+		flag.Usage()
+		os.Exit(16)
+	}
+
+	filter, err := buildMemberFilter(*includeFlag, *excludeFlag, *membersFile)
+	if err != nil {
+		log.Errorln(err)
+		os.Exit(16)
+	}
+
 	// Check if the targert directory exists
 	if _, err := os.Stat(*targetDir); os.IsNotExist(err) {
 		log.Error("Target directory does not exist: ", *targetDir)
 		os.Exit(4)
 	}
 
-	// Check if an unload file is specified. Id so, open it for write
-	// otherwise, create a temporary file
-	if *unloadFile == "" {
-		tempFile, err := os.CreateTemp("", "xmit_unload_*.unload")
-		if err != nil {
-			log.Error("Error creating temporary unload file:", err.Error())
-			os.Exit(8)
-		}
-		// defer tempFile.Close()
-		*unloadFile = tempFile.Name()
-		deleteUnloadFile = true
-	} else {
-		deleteUnloadFile = false
+	opts := extractOptions{
+		typeExt:     *typeExt,
+		unloadFile:  *unloadFile,
+		encoding:    *encoding,
+		aliasPolicy: aliasPolicy,
+		filter:      filter,
+		jobs:        *jobs,
+		maxDepth:    *nestedFlag,
 	}
-
-	// Unconditionally open the unload file for writing
-	unloadFileHandle, err := os.OpenFile(*unloadFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		log.Error("Error opening unload file:", err.Error())
-		os.Exit(8)
+	if *manifestFile != "" {
+		opts.manifest = &manifest{Input: *inputFile}
 	}
 
 	// Open the input file
-	inFile, err := os.Open(*inputFile)
+	entries, inFile, err := openInput(*inputFile, *entryFlag)
 	if err != nil {
-		log.Error("Error opening input file:", err.Error())
+		log.Error("Error opening input file: ", err.Error())
 		os.Exit(8)
 	}
 	defer inFile.Close()
 
+	// Every XMIT file of a zip archive is extracted into a subdirectory named
+	// after its entry, and its unload file gets the entry name as a suffix
+	nfiles := 0
+	for _, entry := range entries {
+		entryOpts := opts
+		entryOpts.targetDir = *targetDir
+		if entry.name != "" {
+			log.Infof("Processing archive entry %s\n", entry.name)
+			entryOpts.targetDir = filepath.Join(*targetDir, entry.dir())
+			if err := os.MkdirAll(entryOpts.targetDir, 0755); err != nil {
+				log.Errorln(err)
+				os.Exit(8)
+			}
+			if opts.unloadFile != "" {
+				entryOpts.unloadFile = opts.unloadFile + "." + filepath.Base(entry.dir())
+			}
+		}
+		entryOpts.entry = entry.name
+		n, err := extractEntry(entry, entryOpts)
+		nfiles += n
+		if err != nil {
+			if entry.name != "" {
+				log.Errorf("Archive entry %s: %v\n", entry.name, err)
+			} else {
+				log.Errorln(err)
+			}
+			rc = 8
+		}
+	}
+
+	log.Infof("%d members expanded from XMIT file %s\n", nfiles, *inputFile)
+	if opts.manifest != nil {
+		if err := opts.manifest.write(*manifestFile); err != nil {
+			log.Error("Error writing manifest file: ", err.Error())
+			rc = 8
+		} else {
+			log.Infof("Manifest written to %s\n", *manifestFile)
+		}
+	}
+	os.Exit(rc)
+}
+
+// extractOptions are the options of the extraction mode
+type extractOptions struct {
+	targetDir   string
+	typeExt     string
+	unloadFile  string
+	encoding    string
+	aliasPolicy unloadfile.AliasPolicy
+	filter      *unloadfile.MemberFilter
+	jobs        int
+	maxDepth    int       // Maximum nesting depth of the XMIT files and unloads to be unpacked
+	depth       int       // Nesting depth of the file being extracted
+	entry       string    // Name of the zip archive entry being extracted
+	manifest    *manifest // Collects the description of the extracted files, if requested
+}
+
+// nestedFunc returns the function that unpacks the XMIT files and IEBCOPY
+// unloads found in members and sequential datasets, or nil when the maximum
+// nesting depth has been reached and they must be written as binary files.
+// Nested files are extracted with the same options, but all their members
+// are selected and their unloads are only kept in memory.
+func (opts extractOptions) nestedFunc() unloadfile.NestedFunc {
+	if opts.depth >= opts.maxDepth {
+		return nil
+	}
+	return func(kind unloadfile.PayloadKind, payload []byte, dir string) error {
+		nestedOpts := opts
+		nestedOpts.targetDir = dir
+		nestedOpts.unloadFile = ""
+		nestedOpts.filter = nil
+		nestedOpts.depth++
+		nestedOpts.entry = ""
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		_, err := extractXMIT(bytes.NewReader(payload), nestedOpts)
+		return err
+	}
+}
+
+// extractEntry extracts the contents of a XMIT file or IEBCOPY unload found
+// in the input file. It returns the number of files written.
+func extractEntry(entry inputEntry, opts extractOptions) (int, error) {
+	in, err := entry.open()
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	return extractXMIT(in, opts)
+}
+
+// extractXMIT extracts the contents of a XMIT file or IEBCOPY unload into
+// opts.targetDir. It returns the number of files written.
+func extractXMIT(in io.Reader, opts extractOptions) (int, error) {
+	// Every transmitted file is reassembled into its own unload, which is
+	// kept in memory unless an unload file name is given. In that case the
+	// second and following files get the file number as a suffix
+	unloads := make([]*unloadData, 0, 1)
+	openUnload := func(fileNumber int, _ *xmitfile.XmitParams) (io.Writer, error) {
+		u := &unloadData{}
+		if opts.unloadFile == "" {
+			u.buffer = new(bytes.Buffer)
+			unloads = append(unloads, u)
+			return u.buffer, nil
+		}
+		u.name = opts.unloadFile
+		if fileNumber > 1 {
+			u.name = fmt.Sprintf("%s.%d", u.name, fileNumber)
+		}
+		f, err := os.OpenFile(u.name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, err
+		}
+		u.file = f
+		u.writer = bufio.NewWriter(f)
+		unloads = append(unloads, u)
+		return u.writer, nil
+	}
+
 	// Process the input file and generate output files
-	xmitParms, err := xmitfile.ProcessXMITFile(inFile, *targetDir, unloadFileHandle, *encoding)
+	xmitParms, err := readInput(in, openUnload, opts.encoding)
+	closeUnloadFiles(unloads)
 	if err != nil {
-		log.Error("Error processing input file: ", err.Error())
-		os.Exit(8)
+		return 0, fmt.Errorf("error processing input file: %w", err)
 	}
+	log.Infof("Using codepage %s for conversion\n", opts.encoding)
 
-	xmf := xmitParms.XmitFiles[0]
-	log.Infof("Original dataset: %s\n", xmf.SourceDSName)
-	log.Infof("Dataset attributes: DSORG=%s, DSTYPE=%s, RECFM=%s, LRECL=%d, BLKSIZE=%d\n",
-		xmf.SourceDsorg, xmf.SourceDstype, xmf.SourceRecfm, xmf.SourceLrecl, xmf.SourceBlksize)
-	log.Infof("Using codepage %s for conversion\n", *encoding)
+	section := manifestSection{
+		Entry:     opts.entry,
+		Directory: opts.targetDir,
+		Depth:     opts.depth,
+		Xmit:      xmitParms,
+		Files:     make([]manifestFile, 0, len(unloads)),
+	}
+	nfiles := 0
+	var errs []error
+	for i, u := range unloads {
+		fileNumber := i + 1
+		xmf, ok := xmitParms.File(fileNumber)
+		if !ok {
+			xmf = xmitParms.FileHeaders[i]
+		}
+		file, err := processDataFile(u, fileNumber, len(unloads) > 1, xmf, opts)
+		nfiles += file.numFiles()
+		if err != nil && err != io.EOF {
+			file.Error = err.Error()
+			errs = append(errs, err)
+		}
+		section.Files = append(section.Files, file)
+	}
+	opts.manifest.add(section)
+	return nfiles, errors.Join(errs...)
+}
 
-	// Close the unload file handle
-	if err := unloadFileHandle.Close(); err != nil {
-		log.Error("Error closing unload file:", err.Error())
-		os.Exit(8)
+// readInput reads the input file, which can be a XMIT file or an IEBCOPY
+// unload. An unload is recognized by the eyecatcher of its COPYR1 record and
+// is passed whole to dataFiles as the only transmitted file, with the dataset
+// attributes taken from COPYR1.
+func readInput(inFile io.Reader, dataFiles xmitfile.DataWriterFunc, encoding string) (*xmitfile.XmitParams, error) {
+	in := bufio.NewReader(inFile)
+	head, _ := in.Peek(unloadfile.Copyr1_size)
+	if !unloadfile.IsUnload(head) {
+		return xmitfile.ProcessXMITFile(in, dataFiles, encoding)
 	}
-	// Reopen the unload file to read its contents
-	unloadFileHandle, err = os.Open(*unloadFile)
+
+	log.Infoln("The input file is an IEBCOPY unload")
+	if len(head) < unloadfile.Copyr1_size {
+		return nil, xmitfile.ReadError(io.ErrUnexpectedEOF, 0, 1, "COPYR1 record")
+	}
+	c1, err := unloadfile.NewCopyr1(head)
 	if err != nil {
-		log.Error("Error reopening unload file for reading:", err.Error())
-		os.Exit(8)
+		return nil, err
+	}
+	xmitParms := xmitfile.NewXmitParams()
+	xmitParms.NumFiles = 1
+	xmitParms.XmitFiles = append(xmitParms.XmitFiles, c1.FileParams())
+	xmitParms.FileHeaders = append(xmitParms.FileHeaders, c1.FileParams())
+	unload, err := dataFiles(1, xmitParms)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(unload, in); err != nil {
+		return nil, err
 	}
-	defer unloadFileHandle.Close()
+	return xmitParms, nil
+}
 
-	nfiles, err := unloadfile.ProcessUnloadFile(*unloadFileHandle, *targetDir, *typeExt, xmf, *encoding)
-	if err != nil && err != io.EOF {
-		log.Errorln(err)
-		rc = 8
+// buildMemberFilter builds the member selection filter from the command line
+// options. It returns nil if no member selection has been requested.
+func buildMemberFilter(include string, exclude string, membersFile string) (*unloadfile.MemberFilter, error) {
+	includes := splitPatterns(include)
+	if membersFile != "" {
+		patterns, err := unloadfile.ReadPatternFile(membersFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read member list: %w", err)
+		}
+		includes = append(includes, patterns...)
 	}
+	excludes := splitPatterns(exclude)
+	if len(includes) == 0 && len(excludes) == 0 {
+		return nil, nil
+	}
+	return unloadfile.NewMemberFilter(includes, excludes)
+}
 
-	err = unloadFileHandle.Close()
-	if err != nil {
-		log.Errorln(err)
+// splitPatterns splits a comma separated list of patterns. The commas inside
+// the braces, brackets or parentheses of a re: pattern, as in re:A{1,3}, do
+// not split it.
+func splitPatterns(list string) []string {
+	patterns := make([]string, 0)
+	for list != "" {
+		end := patternEnd(list)
+		if p := strings.TrimSpace(list[:end]); p != "" {
+			patterns = append(patterns, p)
+		}
+		list = list[min(end+1, len(list)):]
 	}
+	return patterns
+}
 
-	if deleteUnloadFile {
-		// Delete the unload file if it was created as a temporary file
-		if err := os.Remove(*unloadFile); err != nil {
-			log.Warn("Error deleting unload file:", err.Error())
-			rc = 2
-		} else {
-			log.Debugln("Temporary unload file deleted:", *unloadFile)
+// patternEnd returns the position of the comma ending the first pattern of
+// list, or the length of list if there is no such comma
+func patternEnd(list string) int {
+	if !strings.HasPrefix(strings.TrimSpace(list), "re:") {
+		if i := strings.IndexByte(list, ','); i >= 0 {
+			return i
 		}
+		return len(list)
+	}
+	depth := 0
+	inClass := false
+	for i := 0; i < len(list); i++ {
+		switch c := list[i]; {
+		case c == '\\':
+			i++ // Escaped character
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c == '(' || c == '{':
+			depth++
+		case c == ')' || c == '}':
+			depth = max(depth-1, 0)
+		case c == ',' && depth == 0:
+			return i
+		}
+	}
+	return len(list)
+}
+
+// unloadData is the unload of a transmitted file, kept either in memory or
+// in the unload file requested by the user
+type unloadData struct {
+	buffer *bytes.Buffer
+	name   string
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// closeUnloadFiles closes the unload files written while processing the XMIT file
+func closeUnloadFiles(unloads []*unloadData) {
+	for _, u := range unloads {
+		if u.file == nil {
+			continue
+		}
+		if err := u.writer.Flush(); err != nil {
+			log.Error("Error writing unload file:", err.Error())
+		}
+		if err := u.file.Close(); err != nil {
+			log.Error("Error closing unload file:", err.Error())
+		}
+	}
+}
+
+// processDataFile extracts the contents of one transmitted file from its
+// unload file, according to its DSORG. When the XMIT contains more than one
+// file, partitioned datasets are extracted into a subdirectory named after
+// the original dataset. It returns the description of the files written.
+func processDataFile(u *unloadData, fileNumber int, multiFile bool, xmf xmitfile.XmitFileParams, opts extractOptions) (manifestFile, error) {
+	if xmf.IsMessage {
+		log.Infof("File %d: message\n", fileNumber)
+	} else {
+		log.Infof("File %d: original dataset: %s\n", fileNumber, xmf.SourceDSName)
+	}
+	log.Infof("Dataset attributes: DSORG=%s, DSTYPE=%s, RECFM=%s, LRECL=%d, BLKSIZE=%d\n",
+		xmf.SourceDsorg, xmf.SourceDstype, xmf.SourceRecfm, xmf.SourceLrecl, xmf.SourceBlksize)
+
+	var unload interface {
+		io.Reader
+		io.ReaderAt
+	}
+	if u.buffer != nil {
+		unload = bytes.NewReader(u.buffer.Bytes())
+	} else {
+		// Reopen the unload file to read its contents
+		unloadFileHandle, err := os.Open(u.name)
+		if err != nil {
+			return manifestFile{Attributes: xmf}, fmt.Errorf("error reopening unload file for reading: %w", err)
+		}
+		defer unloadFileHandle.Close()
+		unload = unloadFileHandle
+	}
+
+	switch xmf.SourceDsorg {
+	case "PS":
+		// Sequential dataset, no IEBCOPY unload involved
+		if xmf.IsMessage && xmf.SourceDSName == "" {
+			xmf.SourceDSName = fmt.Sprintf("MESSAGE%d", fileNumber)
+		}
+		dataset, err := seqfile.ProcessSequentialFile(unload, opts.targetDir, opts.typeExt, xmf, opts.encoding, opts.nestedFunc())
+		return manifestFile{Attributes: xmf, Dataset: dataset}, err
+	default:
+		targetDir := opts.targetDir
+		if multiFile {
+			targetDir = filepath.Join(targetDir, strings.Trim(xmf.SourceDSName, " "))
+			if err := os.MkdirAll(targetDir, 0755); err != nil {
+				return manifestFile{Attributes: xmf}, err
+			}
+		}
+		extraction, err := unloadfile.ProcessUnloadFile(unload, targetDir, opts.typeExt, xmf, opts.encoding, opts.aliasPolicy, opts.filter, opts.jobs, opts.nestedFunc())
+		return manifestFile{Attributes: xmf, Extraction: extraction}, err
 	}
-	log.Infof("%d members expanded from XMIT file %s\n", nfiles, *inputFile)
-	os.Exit(rc)
 }
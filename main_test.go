@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jguillaumes/xmit_reader/internal/unloadfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitutils"
+)
+
+// textRecords returns the lines as FB 80 records in EBCDIC
+func textRecords(t *testing.T, lines ...string) [][]byte {
+	t.Helper()
+	records := make([][]byte, 0, len(lines))
+	for _, line := range lines {
+		record, err := xmitutils.SharedEncoding.EncodeString(fmt.Sprintf("%-80s", line), "IBM-1047")
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// binaryRecords splits data into FB 80 records, the way a XMIT file is
+// stored in a member
+func binaryRecords(data []byte) [][]byte {
+	records := make([][]byte, 0, len(data)/80)
+	for len(data) >= 80 {
+		records = append(records, data[:80])
+		data = data[80:]
+	}
+	return records
+}
+
+// pdsXMIT returns a XMIT file holding a FB 80 PDS with the given members
+func pdsXMIT(t *testing.T, members []unloadfile.UnloadMember) []byte {
+	t.Helper()
+	var records [][]byte
+	dirBlocks, err := unloadfile.CreateUnload(members, unloadfile.UnloadParams{Recfm: "FB", Lrecl: 80, Blksize: 3120, Encoding: "IBM-1047"},
+		func(r []byte) error {
+			records = append(records, bytes.Clone(r))
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := xmitfile.NewXmitParams()
+	params.NumFiles = 1
+	params.XmitFiles = append(params.XmitFiles,
+		xmitfile.XmitFileParams{FileNumber: 1, SourceDSName: "USER.NESTED.PDS", SourceDsorg: "PO", SourceRecfm: "FB",
+			SourceLrecl: 80, SourceBlksize: 3120, DirBlocks: dirBlocks, UtilPgmName: "IEBCOPY"},
+		xmitfile.XmitFileParams{FileNumber: 1, SourceDsorg: "PS", SourceRecfmHw: 0x4802, SourceLrecl: 32756, SourceBlksize: 3120,
+			UtilPgmName: "INMCOPY"})
+	var xmit bytes.Buffer
+	err = xmitfile.WriteXMITFile(&xmit, params, func(_ int, writeRecord func([]byte) error) error {
+		for _, r := range records {
+			if err := writeRecord(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, "IBM-1047")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return xmit.Bytes()
+}
+
+// TestNestedMembers extracts a PDS whose member MIDDLE holds a XMIT file,
+// whose member JCL holds the sample jgpjcl.xmit, with every nesting depth
+func TestNestedMembers(t *testing.T) {
+	log.SetOutput(io.Discard)
+	inner, err := os.ReadFile(filepath.Join("data", "jgpjcl.xmit"))
+	if err != nil {
+		t.Skip("sample XMIT file not found")
+	}
+	middle := pdsXMIT(t, []unloadfile.UnloadMember{
+		{Name: "JCL", Records: binaryRecords(inner)},
+		{Name: "TEXT", Records: textRecords(t, "A MEMBER OF THE MIDDLE PDS")},
+	})
+	outer := pdsXMIT(t, []unloadfile.UnloadMember{
+		{Name: "MIDDLE", Records: binaryRecords(middle)},
+		{Name: "NOTE", Records: textRecords(t, "A MEMBER OF THE OUTER PDS")},
+	})
+
+	// The members of the sample, as extracted from the file itself
+	sampleDir := t.TempDir()
+	if _, err := extractXMIT(bytes.NewReader(inner), extractOptions{targetDir: sampleDir, typeExt: "txt", encoding: "IBM-1047", jobs: 1}); err != nil {
+		t.Fatal(err)
+	}
+	sampleFiles, err := filepath.Glob(filepath.Join(sampleDir, "*.txt"))
+	if err != nil || len(sampleFiles) == 0 {
+		t.Fatalf("no members extracted from the sample: %v", err)
+	}
+
+	for depth, want := range []map[string][]byte{
+		{"NOTE.txt": nil, "MIDDLE.xmit": middle},
+		{"NOTE.txt": nil, "MIDDLE/TEXT.txt": nil, "MIDDLE/JCL.xmit": inner},
+		{"NOTE.txt": nil, "MIDDLE/TEXT.txt": nil, "MIDDLE/JCL/" + filepath.Base(sampleFiles[0]): nil},
+	} {
+		t.Run(fmt.Sprintf("nested %d", depth), func(t *testing.T) {
+			targetDir := t.TempDir()
+			_, err := extractXMIT(bytes.NewReader(outer), extractOptions{targetDir: targetDir, typeExt: "txt", encoding: "IBM-1047", jobs: 1, maxDepth: depth})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, contents := range want {
+				got, err := os.ReadFile(filepath.Join(targetDir, name))
+				if err != nil {
+					t.Error(err)
+				} else if contents != nil && !bytes.Equal(got, contents) {
+					t.Errorf("%s: %d bytes, want the %d bytes of the nested file", name, len(got), len(contents))
+				}
+			}
+			for _, name := range []string{"MIDDLE.txt", "MIDDLE/JCL.txt"} {
+				if _, err := os.Stat(filepath.Join(targetDir, name)); err == nil {
+					t.Errorf("nested file converted to text as %s", name)
+				}
+			}
+			if depth == 2 {
+				got, _ := filepath.Glob(filepath.Join(targetDir, "MIDDLE", "JCL", "*.txt"))
+				if len(got) != len(sampleFiles) {
+					t.Errorf("%d members unpacked from the innermost file, want %d", len(got), len(sampleFiles))
+				}
+			}
+		})
+	}
+}
+
+// TestManifestKeys checks the keys of the dataset attributes in the
+// manifest, which is read by other tools
+func TestManifestKeys(t *testing.T) {
+	log.SetOutput(io.Discard)
+	in, err := os.Open(filepath.Join("data", "jgppds.xmit"))
+	if err != nil {
+		t.Skip("sample XMIT file not found")
+	}
+	defer in.Close()
+	m := &manifest{Input: "jgppds.xmit"}
+	if _, err := extractXMIT(in, extractOptions{targetDir: t.TempDir(), typeExt: "txt", encoding: "IBM-1047", jobs: 1, manifest: m}); err != nil {
+		t.Fatal(err)
+	}
+	manifestFile := filepath.Join(t.TempDir(), "manifest.json")
+	if err := m.write(manifestFile); err != nil {
+		t.Fatal(err)
+	}
+	file := readManifestFile(t, manifestFile)
+	attributes := file.Attributes
+	for _, key := range []string{"dsname", "ddname", "dsorg", "dstype", "recfm", "lrecl", "blksize"} {
+		if _, found := attributes[key]; !found {
+			t.Errorf("key %q missing from the attributes", key)
+		}
+	}
+	if attributes["dsorg"] != "PO" {
+		t.Errorf("dsorg is %v, want PO", attributes["dsorg"])
+	}
+	if len(file.Members) == 0 {
+		t.Fatal("no members in the manifest")
+	}
+	for _, m := range file.Members {
+		if m.Alias {
+			continue
+		}
+		checkManifestMember(t, m)
+	}
+}
+
+// manifestMember is a member as described in the manifest
+type manifestMember struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Alias   bool   `json:"alias"`
+	Records int    `json:"records"`
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256"`
+}
+
+// manifestFileEntry is a transmitted file as described in the manifest
+type manifestFileEntry struct {
+	Attributes map[string]any   `json:"attributes"`
+	Members    []manifestMember `json:"members"`
+	Error      string           `json:"error"`
+}
+
+// readManifestFile reads a manifest describing a single transmitted file
+func readManifestFile(t *testing.T, manifestFile string) manifestFileEntry {
+	t.Helper()
+	var decoded struct {
+		Extractions []struct {
+			Files []manifestFileEntry `json:"files"`
+		} `json:"extractions"`
+	}
+	data, err := os.ReadFile(manifestFile)
+	if err == nil {
+		err = json.Unmarshal(data, &decoded)
+	}
+	if err != nil || len(decoded.Extractions) != 1 || len(decoded.Extractions[0].Files) != 1 {
+		t.Fatalf("unexpected manifest (%v):\n%s", err, data)
+	}
+	return decoded.Extractions[0].Files[0]
+}
+
+// checkManifestMember checks the path, size, checksum and number of records
+// of a member against the file written
+func checkManifestMember(t *testing.T, m manifestMember) {
+	t.Helper()
+	if m.Path == "" {
+		t.Errorf("member %s: no path", m.Name)
+		return
+	}
+	data, err := os.ReadFile(m.Path)
+	if err != nil {
+		t.Errorf("member %s: %v", m.Name, err)
+		return
+	}
+	if m.Bytes != int64(len(data)) {
+		t.Errorf("member %s: %d bytes, want %d", m.Name, m.Bytes, len(data))
+	}
+	if sum := fmt.Sprintf("%x", sha256.Sum256(data)); m.SHA256 != sum {
+		t.Errorf("member %s: SHA-256 %s, want %s", m.Name, m.SHA256, sum)
+	}
+	if lines := bytes.Count(data, []byte("\n")); m.Records != lines {
+		t.Errorf("member %s: %d records, want %d", m.Name, m.Records, lines)
+	}
+}
+
+// TestManifestFailedExtraction checks that the members written before an
+// extraction fails are recorded in the manifest with the error
+func TestManifestFailedExtraction(t *testing.T) {
+	log.SetOutput(io.Discard)
+	xmitData := pdsXMIT(t, []unloadfile.UnloadMember{
+		{Name: "ALPHA", Records: textRecords(t, "THE FIRST MEMBER")},
+		{Name: "BETA", Records: textRecords(t, "A MEMBER THAT CANNOT BE WRITTEN")},
+	})
+	targetDir := t.TempDir()
+	// A directory in the way of the second member
+	if err := os.Mkdir(filepath.Join(targetDir, "BETA.txt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	m := &manifest{Input: "test.xmit"}
+	if _, err := extractXMIT(bytes.NewReader(xmitData), extractOptions{targetDir: targetDir, typeExt: "txt", encoding: "IBM-1047", jobs: 1, manifest: m}); err == nil {
+		t.Fatal("extraction did not fail")
+	}
+	manifestFile := filepath.Join(t.TempDir(), "manifest.json")
+	if err := m.write(manifestFile); err != nil {
+		t.Fatal(err)
+	}
+	file := readManifestFile(t, manifestFile)
+	if file.Error == "" {
+		t.Error("error not recorded in the manifest")
+	}
+	if len(file.Members) != 1 || file.Members[0].Name != "ALPHA" {
+		t.Fatalf("members %+v, want ALPHA only", file.Members)
+	}
+	checkManifestMember(t, file.Members[0])
+}
+
+func TestSplitPatterns(t *testing.T) {
+	for _, tc := range []struct {
+		list string
+		want []string
+	}{
+		{"", []string{}},
+		{"ALPHA", []string{"ALPHA"}},
+		{"A*, B%C ,,", []string{"A*", "B%C"}},
+		{"re:A{1,3}", []string{"re:A{1,3}"}},
+		{"re:A{1,3},B*", []string{"re:A{1,3}", "B*"}},
+		{"B*, re:(X|Y){2,},C", []string{"B*", "re:(X|Y){2,}", "C"}},
+		{"re:[,A]B,C", []string{"re:[,A]B", "C"}},
+		{`re:A\{1,B`, []string{`re:A\{1`, "B"}},
+		{"A{1,3}", []string{"A{1", "3}"}},
+	} {
+		if got := splitPatterns(tc.list); !slices.Equal(got, tc.want) {
+			t.Errorf("splitPatterns(%q) = %q, want %q", tc.list, got, tc.want)
+		}
+	}
+
+	filter, err := buildMemberFilter("re:A{1,3}", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Selected("AAA") || filter.Selected("AAAA") {
+		t.Error("regular expression with a comma not applied")
+	}
+}
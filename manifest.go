@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/jguillaumes/xmit_reader/internal/unloadfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitfile"
+)
+
+// manifest describes everything written by an extraction. Every XMIT file or
+// IEBCOPY unload extracted, including the nested ones, has its own section.
+type manifest struct {
+	Input    string            `json:"input"`
+	Sections []manifestSection `json:"extractions"`
+
+	mu sync.Mutex
+}
+
+// manifestSection describes the extraction of a XMIT file or IEBCOPY unload
+// into Directory
+type manifestSection struct {
+	Entry     string               `json:"entry,omitempty"` // Zip archive entry
+	Directory string               `json:"directory"`
+	Depth     int                  `json:"depth"` // Nesting depth, 0 for the input file
+	Xmit      *xmitfile.XmitParams `json:"xmit"`
+	Files     []manifestFile       `json:"files"`
+}
+
+// manifestFile describes the extraction of a transmitted file. A sequential
+// dataset is written to a single file, described by Dataset. A partitioned
+// dataset comes with the COPYR1 and COPYR2 records of its unload and the
+// list of its members.
+type manifestFile struct {
+	Attributes xmitfile.XmitFileParams   `json:"attributes"`
+	Dataset    *unloadfile.ExtractedFile `json:"dataset,omitempty"`
+	*unloadfile.Extraction
+	Error string `json:"error,omitempty"`
+}
+
+// numFiles returns the number of datasets and members written, aliases not
+// included
+func (f manifestFile) numFiles() int {
+	switch {
+	case f.Dataset != nil:
+		return 1
+	case f.Extraction != nil:
+		return f.NumMembers()
+	default:
+		return 0
+	}
+}
+
+// add adds a section to the manifest. Nested files are extracted
+// concurrently, so it can be called from several goroutines.
+func (m *manifest) add(section manifestSection) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Sections = append(m.Sections, section)
+}
+
+// write writes the manifest in JSON format. The sections are sorted by
+// directory, so nested files follow the file they were found in whatever
+// the order they were extracted.
+func (m *manifest) write(fileName string) error {
+	sort.SliceStable(m.Sections, func(i, j int) bool {
+		return m.Sections[i].Directory < m.Sections[j].Directory
+	})
+	marshalled, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, append(marshalled, '\n'), 0644)
+}
@@ -0,0 +1,363 @@
+package xmit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jguillaumes/xmit_reader/internal/seqfile"
+	"github.com/jguillaumes/xmit_reader/internal/unloadfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitfile"
+)
+
+// FS is a read-only file system over the contents of a XMIT file. Every
+// partitioned dataset is a directory in the root, named after the dataset,
+// holding a file for each member and alias. Sequential datasets and messages
+// are files in the root. Files contain the records converted to text, one
+// line per record.
+//
+// The Sys method of the fs.FileInfo of datasets and members returns a
+// *Header with the DCB attributes and, for members, the ISPF statistics.
+//
+// The IEBCOPY unloads are kept in memory and members are only converted when
+// they are opened, using the member offsets found in the unload.
+type FS struct {
+	params   *Params
+	encoding string
+	root     []*fsDataset
+	byName   map[string]*fsDataset
+}
+
+// fsDataset is a transmitted dataset
+type fsDataset struct {
+	name    string
+	header  Header
+	modTime time.Time
+	text    []byte               // Contents of a sequential dataset
+	unload  []byte               // IEBCOPY unload of a partitioned dataset
+	members map[string]*fsMember // Members and aliases by name
+	names   []string             // Sorted member and alias names
+	mu      sync.Mutex           // Protects the member sizes
+	sizes   map[*unloadfile.MemberEntry]int64
+}
+
+type fsMember struct {
+	entry  *unloadfile.MemberEntry
+	header Header
+}
+
+// OpenFS reads the XMIT file with the given name and returns its file system.
+func OpenFS(name string, opts Options) (*FS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewFS(f, opts)
+}
+
+// NewFS reads a XMIT file from r and returns its file system. Members not
+// selected by the Include and Exclude options are left out.
+func NewFS(r io.Reader, opts Options) (*FS, error) {
+	encoding := opts.encoding()
+	filter, err := newFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+	// Load the encoding table now, so an unknown encoding is reported here
+	// and not when the members are opened
+	if _, err := enc.GetDecodingTableFor(encoding); err != nil {
+		return nil, err
+	}
+
+	buffers := make([]*bytes.Buffer, 0, 1)
+	newBuffer := func(fileNumber int, _ *xmitfile.XmitParams) (io.Writer, error) {
+		b := new(bytes.Buffer)
+		buffers = append(buffers, b)
+		return b, nil
+	}
+	params, err := xmitfile.ProcessXMITFile(r, newBuffer, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	fsys := &FS{
+		params:   params,
+		encoding: encoding,
+		byName:   make(map[string]*fsDataset, len(buffers)),
+	}
+	for i, b := range buffers {
+		fileNumber := i + 1
+		fp, ok := params.File(fileNumber)
+		if !ok {
+			fp = params.FileHeaders[i]
+		}
+		ds := &fsDataset{
+			name:    datasetName(fp, fileNumber),
+			header:  Header{FileNumber: fileNumber, FileParams: fp},
+			modTime: datasetTime(fp, params),
+		}
+		if fp.SourceDsorg == "PO" {
+			err = ds.readDirectory(b.Bytes(), filter, encoding)
+		} else {
+			var text bytes.Buffer
+			_, err = seqfile.ReadRecords(b, func(record []byte) error {
+				line, err := enc.DecodeBytes(record, encoding)
+				text.WriteString(line)
+				text.WriteByte('\n')
+				return err
+			})
+			ds.text = text.Bytes()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("file %d: %w", fileNumber, err)
+		}
+		if _, dup := fsys.byName[ds.name]; dup {
+			ds.name = fmt.Sprintf("%s.%d", ds.name, fileNumber)
+		}
+		fsys.root = append(fsys.root, ds)
+		fsys.byName[ds.name] = ds
+	}
+	sort.Slice(fsys.root, func(i, j int) bool { return fsys.root[i].name < fsys.root[j].name })
+	return fsys, nil
+}
+
+// datasetName returns the name of the directory or file of a dataset
+func datasetName(fp FileParams, fileNumber int) string {
+	name := strings.Trim(fp.SourceDSName, " ")
+	if name != "" {
+		return name
+	}
+	if fp.IsMessage {
+		return fmt.Sprintf("MESSAGE%d", fileNumber)
+	}
+	return fmt.Sprintf("FILE%d", fileNumber)
+}
+
+// datasetTime returns the modification time of a dataset: its last change
+// date if known, otherwise the time it was transmitted
+func datasetTime(fp FileParams, params *Params) time.Time {
+	switch {
+	case !fp.LastChanged.IsZero():
+		return fp.LastChanged
+	case !fp.SourceCreation.IsZero():
+		return fp.SourceCreation
+	default:
+		return params.SourceTstamp
+	}
+}
+
+// readDirectory reads the directory of the IEBCOPY unload of a partitioned
+// dataset
+func (ds *fsDataset) readDirectory(unload []byte, filter *unloadfile.MemberFilter, encoding string) error {
+	entries, c1, c2, err := unloadfile.ReadUnloadDirectory(bytes.NewReader(unload), encoding)
+	if err != nil {
+		return err
+	}
+	ds.unload = unload
+	ds.header.Copyr1 = c1
+	ds.header.Copyr2 = c2
+	ds.members = make(map[string]*fsMember, len(entries))
+	ds.sizes = make(map[*unloadfile.MemberEntry]int64, len(entries))
+	for ttr, entry := range entries {
+		name, aliases, ok := selectedNames(filter, &entry)
+		if !ok {
+			continue
+		}
+		hdr := ds.header
+		hdr.Member, hdr.Aliases = name, aliases
+		hdr.TTR = ttr
+		hdr.Stats = entry.Stats
+		m := &fsMember{entry: &entry, header: hdr}
+		for _, n := range append([]string{name}, aliases...) {
+			ds.members[n] = m
+			ds.names = append(ds.names, n)
+		}
+	}
+	sort.Strings(ds.names)
+	return nil
+}
+
+// memberText converts the records of a member to text
+func (ds *fsDataset) memberText(m *fsMember, encoding string) ([]byte, error) {
+	var text bytes.Buffer
+	err := unloadfile.ReadMemberRecords(bytes.NewReader(ds.unload), *m.entry, ds.header.FileParams, encoding, func(record []byte) error {
+		line, err := enc.DecodeBytes(record, encoding)
+		text.WriteString(line)
+		text.WriteByte('\n')
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ds.mu.Lock()
+	ds.sizes[m.entry] = int64(text.Len())
+	ds.mu.Unlock()
+	return text.Bytes(), nil
+}
+
+// memberInfo returns the file information of a member or alias. Its size is
+// only known once the member has been converted.
+func (fsys *FS) memberInfo(ds *fsDataset, name string) (*fileInfo, error) {
+	m := ds.members[name]
+	ds.mu.Lock()
+	size, known := ds.sizes[m.entry]
+	ds.mu.Unlock()
+	if !known {
+		text, err := ds.memberText(m, fsys.encoding)
+		if err != nil {
+			return nil, err
+		}
+		size = int64(len(text))
+	}
+	modTime := ds.modTime
+	if m.header.Stats != nil {
+		modTime = m.header.Stats.Changed
+	}
+	hdr := m.header
+	return &fileInfo{name: name, size: size, mode: 0444, modTime: modTime, sys: &hdr}, nil
+}
+
+func (ds *fsDataset) info() *fileInfo {
+	hdr := ds.header
+	if ds.members != nil {
+		return &fileInfo{name: ds.name, mode: fs.ModeDir | 0555, modTime: ds.modTime, sys: &hdr}
+	}
+	return &fileInfo{name: ds.name, size: int64(len(ds.text)), mode: 0444, modTime: ds.modTime, sys: &hdr}
+}
+
+// Params returns the transmission metadata of the XMIT file
+func (fsys *FS) Params() *Params {
+	return fsys.params
+}
+
+// Open opens the named file or directory
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		entries := make([]fs.DirEntry, 0, len(fsys.root))
+		for _, ds := range fsys.root {
+			entries = append(entries, ds.info())
+		}
+		info := &fileInfo{name: ".", mode: fs.ModeDir | 0555, modTime: fsys.params.SourceTstamp}
+		return &fsDir{info: info, entries: entries}, nil
+	}
+
+	dsName, memberName, isMember := strings.Cut(name, "/")
+	ds, found := fsys.byName[dsName]
+	if !found || isMember && (ds.members == nil || strings.Contains(memberName, "/")) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !isMember {
+		if ds.members == nil {
+			return &fsFile{info: ds.info(), r: bytes.NewReader(ds.text)}, nil
+		}
+		entries := make([]fs.DirEntry, 0, len(ds.names))
+		for _, n := range ds.names {
+			entries = append(entries, &lazyDirEntry{name: n, info: func() (fs.FileInfo, error) { return fsys.memberInfo(ds, n) }})
+		}
+		return &fsDir{info: ds.info(), entries: entries}, nil
+	}
+
+	m, found := ds.members[memberName]
+	if !found {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	text, err := ds.memberText(m, fsys.encoding)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	info, err := fsys.memberInfo(ds, memberName)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &fsFile{info: info, r: bytes.NewReader(text)}, nil
+}
+
+// fileInfo describes a file or directory of a FS. It is both a fs.FileInfo
+// and a fs.DirEntry.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	sys     *Header
+}
+
+func (fi *fileInfo) Name() string               { return path.Base(fi.name) }
+func (fi *fileInfo) Size() int64                { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode          { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time         { return fi.modTime }
+func (fi *fileInfo) IsDir() bool                { return fi.mode.IsDir() }
+func (fi *fileInfo) Type() fs.FileMode          { return fi.mode.Type() }
+func (fi *fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+func (fi *fileInfo) String() string             { return fs.FormatFileInfo(fi) }
+
+func (fi *fileInfo) Sys() any {
+	if fi.sys == nil {
+		return nil // The root directory
+	}
+	return fi.sys
+}
+
+// lazyDirEntry is the directory entry of a member, whose size is only
+// computed when its information is requested
+type lazyDirEntry struct {
+	name string
+	info func() (fs.FileInfo, error)
+}
+
+func (de *lazyDirEntry) Name() string               { return de.name }
+func (de *lazyDirEntry) IsDir() bool                { return false }
+func (de *lazyDirEntry) Type() fs.FileMode          { return 0 }
+func (de *lazyDirEntry) Info() (fs.FileInfo, error) { return de.info() }
+func (de *lazyDirEntry) String() string             { return fs.FormatDirEntry(de) }
+
+// fsFile is an open dataset or member
+type fsFile struct {
+	info *fileInfo
+	r    *bytes.Reader
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error)                   { return f.info, nil }
+func (f *fsFile) Read(p []byte) (int, error)                   { return f.r.Read(p) }
+func (f *fsFile) ReadAt(p []byte, off int64) (int, error)      { return f.r.ReadAt(p, off) }
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) { return f.r.Seek(offset, whence) }
+func (f *fsFile) Close() error                                 { return nil }
+
+// fsDir is an open directory: the root or a partitioned dataset
+type fsDir struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *fsDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
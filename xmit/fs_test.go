@@ -0,0 +1,86 @@
+package xmit
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestFS(t *testing.T) {
+	log.SetOutput(io.Discard)
+	for _, tc := range []struct {
+		name     string
+		expected []string
+	}{
+		{"jgpjcl.xmit", []string{"JGUILLA.JGP.JCL"}},
+		{"jgppds.xmit", []string{"JGUILLA.JGP.PLI"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys, err := OpenFS(sample(tc.name), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := fstest.TestFS(fsys, tc.expected...); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestFSUnknownEncoding(t *testing.T) {
+	if _, err := OpenFS(sample("jgpjcl.xmit"), Options{Encoding: "IBM-9999"}); err == nil {
+		t.Error("no error for an unknown encoding")
+	}
+}
+
+// TestFSConcurrentRead reads every member of a FS from several goroutines
+// at once. Run it with -race.
+func TestFSConcurrentRead(t *testing.T) {
+	log.SetOutput(io.Discard)
+	fsys, err := OpenFS(sample("jgpjcl.xmit"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := fs.Glob(fsys, "*/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatal("no members found")
+	}
+	want := make(map[string][]byte, len(names))
+	for _, name := range names {
+		if want[name], err = fs.ReadFile(fsys, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A new FS, so the member sizes are computed concurrently too
+	fsys, err = OpenFS(sample("jgpjcl.xmit"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, name := range names {
+				got, err := fs.ReadFile(fsys, name)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(got, want[name]) {
+					t.Errorf("%s: different contents", name)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,259 @@
+package xmit
+
+import (
+	"errors"
+	"io"
+
+	"github.com/jguillaumes/xmit_reader/internal/seqfile"
+	"github.com/jguillaumes/xmit_reader/internal/unloadfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitfile"
+)
+
+// Header describes an entry of a XMIT file returned by Reader.Next. There is
+// an entry for every transmitted file and, after the entry of a partitioned
+// dataset, an entry for every one of its members.
+type Header struct {
+	FileNumber int        // Number of the transmitted file, starting at 1
+	FileParams FileParams // Attributes of the original dataset
+	Copyr1     *Copyr1    // IEBCOPY unload header, partitioned datasets only
+	Copyr2     *Copyr2    // IEBCOPY unload extents, partitioned datasets only
+
+	Member  string     // Member name, empty for the entry of a file
+	Aliases []string   // Alias names of the member
+	TTR     uint32     // Relative track and record of the member data
+	Stats   *IspfStats // ISPF statistics of the member, if any
+}
+
+// IsMember tells if the entry is a member of a partitioned dataset
+func (h *Header) IsMember() bool {
+	return h.Member != ""
+}
+
+// streamFile is a transmitted file whose data is being reassembled
+type streamFile struct {
+	number int
+	params FileParams
+	xmit   Params
+	data   *io.PipeReader
+}
+
+// Reader provides sequential access to the contents of a XMIT file, like
+// archive/tar.Reader. Next advances to the next entry, a transmitted file or
+// a member, and Read or ReadRecord return the data of that entry.
+//
+// The input is read as a stream and is never seeked, so it can come straight
+// from a network connection. The data of an entry is only available until
+// Next is called again.
+type Reader struct {
+	r        io.Reader
+	encoding string
+	filter   *unloadfile.MemberFilter
+	filtErr  error
+
+	started bool
+	files   chan *streamFile
+	done    chan struct{}
+	result  *Params // Set by the reassembly before closing files
+	resErr  error
+	params  *Params
+	err     error
+
+	file   *streamFile
+	unload *unloadfile.UnloadReader
+	next   func() ([]byte, error) // Returns the records of the current entry
+	text   []byte                 // Text of the current record not yet read
+}
+
+// NewReader creates a new Reader reading from r.
+func NewReader(r io.Reader, opts Options) *Reader {
+	filter, err := newFilter(opts)
+	return &Reader{
+		r:        r,
+		encoding: opts.encoding(),
+		filter:   filter,
+		filtErr:  err,
+		files:    make(chan *streamFile),
+		done:     make(chan struct{}),
+	}
+}
+
+// start reassembles the XMIT data records in the background. The data of
+// every transmitted file is sent through its own pipe.
+func (tr *Reader) start() {
+	tr.started = true
+	go func() {
+		var current *io.PipeWriter
+		newFile := func(fileNumber int, xmitParms *xmitfile.XmitParams) (io.Writer, error) {
+			if current != nil {
+				current.Close()
+			}
+			fp, ok := xmitParms.File(fileNumber)
+			if !ok {
+				fp = xmitParms.FileHeaders[fileNumber-1]
+			}
+			pr, pw := io.Pipe()
+			current = pw
+			select {
+			case tr.files <- &streamFile{number: fileNumber, params: fp, xmit: *xmitParms, data: pr}:
+				return pw, nil
+			case <-tr.done:
+				return nil, errReaderClosed
+			}
+		}
+		params, err := xmitfile.ProcessXMITFile(tr.r, newFile, tr.encoding)
+		if current != nil {
+			current.CloseWithError(err)
+		}
+		tr.result = params
+		tr.resErr = err
+		close(tr.files)
+	}()
+}
+
+var errReaderClosed = errors.New("xmit: reader closed")
+
+// Params returns the transmission metadata. The INMR01 and INMR02
+// information is available after the first call to Next; the information of
+// the INMR03 records is complete once Next has returned io.EOF.
+func (tr *Reader) Params() *Params {
+	if tr.params != nil {
+		return tr.params
+	}
+	if tr.file != nil {
+		return &tr.file.xmit
+	}
+	return nil
+}
+
+// Next advances to the next entry and returns its header. It returns io.EOF
+// at the end of the XMIT file.
+func (tr *Reader) Next() (*Header, error) {
+	if tr.filtErr != nil {
+		return nil, tr.filtErr
+	}
+	if !tr.started {
+		tr.start()
+	}
+	tr.next = nil
+	tr.text = nil
+
+	// Next member of the current partitioned dataset
+	for tr.unload != nil {
+		entry, ttr, err := tr.unload.NextMember()
+		if err == io.EOF {
+			tr.unload = nil
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		name, aliases, ok := selectedNames(tr.filter, entry)
+		if !ok {
+			continue
+		}
+		hdr := tr.fileHeader()
+		hdr.Member, hdr.Aliases = name, aliases
+		hdr.TTR = ttr
+		hdr.Stats = entry.Stats
+		tr.next = tr.unload.NextRecord
+		return hdr, nil
+	}
+
+	// Next transmitted file. The rest of the current one is discarded so
+	// the reassembly can go on
+	if tr.file != nil {
+		if _, err := io.Copy(io.Discard, tr.file.data); err != nil {
+			return nil, err
+		}
+	}
+	file, ok := <-tr.files
+	if !ok {
+		tr.file = nil
+		tr.params, tr.err = tr.result, tr.resErr
+		if tr.err != nil {
+			return nil, tr.err
+		}
+		return nil, io.EOF
+	}
+	tr.file = file
+	hdr := tr.fileHeader()
+	if file.params.SourceDsorg == "PO" {
+		unload, err := unloadfile.NewUnloadReader(file.data, file.params, tr.encoding)
+		if err != nil {
+			return nil, err
+		}
+		tr.unload = unload
+		hdr.Copyr1 = unload.Copyr1
+		hdr.Copyr2 = unload.Copyr2
+	} else {
+		tr.next = seqfile.NewRecordReader(file.data).Next
+	}
+	return hdr, nil
+}
+
+func (tr *Reader) fileHeader() *Header {
+	hdr := &Header{
+		FileNumber: tr.file.number,
+		FileParams: tr.file.params,
+	}
+	if tr.unload != nil {
+		hdr.Copyr1 = tr.unload.Copyr1
+		hdr.Copyr2 = tr.unload.Copyr2
+	}
+	return hdr
+}
+
+// ReadRecord returns the next record of the current entry, in EBCDIC and
+// without record descriptor word. It returns io.EOF at the end of the entry.
+// The entry of a partitioned dataset has no records; its data is in the
+// entries of its members.
+func (tr *Reader) ReadRecord() ([]byte, error) {
+	if tr.next == nil {
+		return nil, io.EOF
+	}
+	record, err := tr.next()
+	if err != nil {
+		tr.next = nil
+	}
+	return record, err
+}
+
+// Read reads the data of the current entry converted to text, one line per
+// record. It returns io.EOF at the end of the entry.
+func (tr *Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(tr.text) == 0 {
+			record, err := tr.ReadRecord()
+			if err != nil {
+				if n > 0 && err == io.EOF {
+					return n, nil
+				}
+				return n, err
+			}
+			line, err := enc.DecodeBytes(record, tr.encoding)
+			if err != nil {
+				return n, err
+			}
+			tr.text = append([]byte(line), '\n')
+		}
+		copied := copy(p[n:], tr.text)
+		tr.text = tr.text[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// Close stops reading the XMIT file. It must be called when the Reader is
+// not read until Next returns io.EOF, so the background reassembly ends.
+// It does not close the underlying reader.
+func (tr *Reader) Close() error {
+	select {
+	case <-tr.done:
+	default:
+		close(tr.done)
+	}
+	if tr.file != nil {
+		tr.file.data.CloseWithError(errReaderClosed)
+	}
+	return nil
+}
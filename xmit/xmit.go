@@ -0,0 +1,275 @@
+// Package xmit reads MVS TRANSMIT (XMIT) files, as generated by the TSO
+// TRANSMIT command, and returns their contents: the transmission metadata,
+// the attributes of every transmitted dataset and its data. Sequential
+// datasets and messages are returned as a list of records, and partitioned
+// datasets (PDS and PDSE) as a list of members. Nothing is written to disk.
+//
+// Records are returned as they were in the original dataset, in EBCDIC and
+// without record descriptor words. The Text methods convert them to lines of
+// text using the encoding given in the Options.
+//
+// The functions of the package can be called from several goroutines at
+// once, and an Archive or a FS can be read concurrently. A Reader must only
+// be used by one goroutine at a time.
+package xmit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jguillaumes/xmit_reader/internal/unloadfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitutils"
+)
+
+// DefaultEncoding is the EBCDIC code page used when Options.Encoding is empty
+const DefaultEncoding = "IBM-1047"
+
+var enc = xmitutils.SharedEncoding
+
+// Params holds the transmission information from the INMR01 record (origin
+// and target node and user, timestamps) and the INMR02 and INMR03 records of
+// every transmitted file.
+type Params = xmitfile.XmitParams
+
+// FileParams holds the attributes of a transmitted dataset (name, DSORG,
+// RECFM, LRECL, BLKSIZE, space and dates) taken from its INMR02 records.
+type FileParams = xmitfile.XmitFileParams
+
+// IspfStats are the ISPF statistics of a member of a source library.
+type IspfStats = unloadfile.IspfStats
+
+// Copyr1 is the first header record of an IEBCOPY unload, holding the DCB
+// attributes of the unloaded partitioned dataset.
+type Copyr1 = unloadfile.Copyr1
+
+// Copyr2 is the second header record of an IEBCOPY unload, holding the
+// extents of the unloaded partitioned dataset.
+type Copyr2 = unloadfile.Copyr2
+
+// FormatError is returned for the problems found in the data of a XMIT file
+// or of the IEBCOPY unload of a partitioned dataset. It holds the byte
+// offset and the record number where the problem was found, and matches one
+// of the error kinds below with errors.Is.
+type FormatError = xmitfile.FormatError
+
+// Kinds of FormatError
+var (
+	ErrTruncated         = xmitfile.ErrTruncated         // The input ends in the middle of a record
+	ErrNotXMIT           = xmitfile.ErrNotXMIT           // The input does not start with an INMR01 record
+	ErrUnsupportedRecfm  = xmitfile.ErrUnsupportedRecfm  // The records of the dataset cannot be deblocked
+	ErrCorruptDirectory  = xmitfile.ErrCorruptDirectory  // Invalid COPYR1, COPYR2 or directory records
+	ErrMissingMemberData = xmitfile.ErrMissingMemberData // No data found for a member listed in the directory
+	ErrCorruptData       = xmitfile.ErrCorruptData       // Invalid segment, record or block lengths
+)
+
+// Options control how a XMIT file is read.
+type Options struct {
+	// Encoding is the EBCDIC code page of the transmitted data, used to
+	// decode member names and by the Text methods. DefaultEncoding is used
+	// if it is empty.
+	Encoding string
+
+	// Include and Exclude select the members of partitioned datasets. They
+	// are TSO member masks (ABC*, A%C) or regular expressions prefixed by
+	// "re:". Every member and alias name is selected on its own: it is kept
+	// if it matches any Include pattern, or there are none, and does not
+	// match any Exclude pattern. A member whose name is not selected is
+	// returned under its first selected alias, and it is left out if none
+	// is.
+	Include []string
+	Exclude []string
+}
+
+func (o Options) encoding() string {
+	if o.Encoding == "" {
+		return DefaultEncoding
+	}
+	return o.Encoding
+}
+
+// Archive is the contents of a XMIT file.
+type Archive struct {
+	Params *Params // Transmission metadata
+	Files  []*File // Transmitted files, in transmission order
+}
+
+// File is a transmitted file: a dataset or a message.
+type File struct {
+	Number  int        // File number, starting at 1
+	Params  FileParams // Attributes of the original dataset
+	Copyr1  *Copyr1    // IEBCOPY unload header, partitioned datasets only
+	Copyr2  *Copyr2    // IEBCOPY unload extents, partitioned datasets only
+	Records [][]byte   // Records of a sequential dataset or message
+	Members []*Member  // Members of a partitioned dataset, sorted by name
+
+	encoding string
+}
+
+// IsPartitioned tells if the file is a partitioned dataset (PDS or PDSE)
+func (f *File) IsPartitioned() bool {
+	return f.Params.SourceDsorg == "PO"
+}
+
+// Text returns the records of a sequential dataset converted to text, one
+// line per record.
+func (f *File) Text() ([]byte, error) {
+	return recordsText(f.Records, f.encoding)
+}
+
+// Member returns the member of a partitioned dataset with the given name or
+// alias, or nil if there is none.
+func (f *File) Member(name string) *Member {
+	name = strings.ToUpper(name)
+	for _, m := range f.Members {
+		if m.Name == name {
+			return m
+		}
+		for _, a := range m.Aliases {
+			if a == name {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// Member is a member of a partitioned dataset.
+type Member struct {
+	Name    string     // Member name, without trailing blanks
+	Aliases []string   // Alias names pointing to the member data
+	TTR     uint32     // Relative track and record of the member data
+	Stats   *IspfStats // ISPF statistics, nil if the member has none
+	Records [][]byte   // Logical records of the member
+
+	encoding string
+}
+
+// Text returns the records of the member converted to text, one line per
+// record.
+func (m *Member) Text() ([]byte, error) {
+	return recordsText(m.Records, m.encoding)
+}
+
+// Size returns the number of bytes in the records of the member
+func (m *Member) Size() int64 {
+	var size int64
+	for _, r := range m.Records {
+		size += int64(len(r))
+	}
+	return size
+}
+
+func recordsText(records [][]byte, encoding string) ([]byte, error) {
+	var text bytes.Buffer
+	for _, r := range records {
+		line, err := enc.DecodeBytes(r, encoding)
+		if err != nil {
+			return nil, err
+		}
+		text.WriteString(line)
+		text.WriteByte('\n')
+	}
+	return text.Bytes(), nil
+}
+
+// Open reads the XMIT file with the given name.
+func Open(name string, opts Options) (*Archive, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f, opts)
+}
+
+// Read reads a XMIT file from r. The whole contents are kept in memory.
+func Read(r io.Reader, opts Options) (*Archive, error) {
+	tr := NewReader(r, opts)
+	defer tr.Close()
+
+	archive := &Archive{}
+	var file *File
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			if file != nil {
+				err = fmt.Errorf("file %d: %w", file.Number, err)
+			}
+			return nil, err
+		}
+		if !hdr.IsMember() {
+			file = &File{
+				Number:   hdr.FileNumber,
+				Params:   hdr.FileParams,
+				Copyr1:   hdr.Copyr1,
+				Copyr2:   hdr.Copyr2,
+				encoding: tr.encoding,
+			}
+			archive.Files = append(archive.Files, file)
+			file.Records, err = readRecords(tr)
+		} else {
+			m := &Member{
+				Name:     hdr.Member,
+				Aliases:  hdr.Aliases,
+				TTR:      hdr.TTR,
+				Stats:    hdr.Stats,
+				encoding: tr.encoding,
+			}
+			file.Members = append(file.Members, m)
+			m.Records, err = readRecords(tr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("file %d: %w", file.Number, err)
+		}
+	}
+	for _, f := range archive.Files {
+		sort.Slice(f.Members, func(i, j int) bool { return f.Members[i].Name < f.Members[j].Name })
+	}
+	archive.Params = tr.Params()
+	return archive, nil
+}
+
+// readRecords reads all the records of the current entry of tr
+func readRecords(tr *Reader) ([][]byte, error) {
+	var records [][]byte
+	for {
+		record, err := tr.ReadRecord()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, bytes.Clone(record))
+	}
+}
+
+// selectedNames returns the names of a member selected by the filter: its
+// own name, or its first selected alias if it is not selected, and the rest
+// of its selected aliases. ok is false if no name is selected.
+func selectedNames(filter *unloadfile.MemberFilter, entry *unloadfile.MemberEntry) (name string, aliases []string, ok bool) {
+	for _, n := range append([]string{entry.MemberName}, entry.Aliases...) {
+		if !filter.Selected(n) {
+			continue
+		}
+		if n = strings.TrimRight(n, " "); !ok {
+			name, ok = n, true
+		} else {
+			aliases = append(aliases, n)
+		}
+	}
+	return name, aliases, ok
+}
+
+func newFilter(opts Options) (*unloadfile.MemberFilter, error) {
+	if len(opts.Include) == 0 && len(opts.Exclude) == 0 {
+		return nil, nil
+	}
+	return unloadfile.NewMemberFilter(opts.Include, opts.Exclude)
+}
@@ -0,0 +1,329 @@
+package xmit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jguillaumes/xmit_reader/internal/unloadfile"
+	"github.com/jguillaumes/xmit_reader/internal/xmitfile"
+)
+
+// sample returns the path of a sample XMIT file of the repository
+func sample(name string) string {
+	return filepath.Join("..", "data", name)
+}
+
+// buildXMIT returns a XMIT file holding a FB 80 PDS with the given members,
+// each one with three lines of text. patch, if not nil, can change the
+// records of the unload before they are transmitted.
+func buildXMIT(t *testing.T, recfm string, members []string, patch func(records [][]byte)) []byte {
+	t.Helper()
+	unloadMembers := make([]unloadfile.UnloadMember, 0, len(members))
+	for _, name := range members {
+		m := unloadfile.UnloadMember{Name: name}
+		for i := range 3 {
+			record, err := enc.EncodeString(fmt.Sprintf("%-80s", fmt.Sprintf("LINE %d OF MEMBER %s", i+1, name)), DefaultEncoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Records = append(m.Records, record)
+		}
+		unloadMembers = append(unloadMembers, m)
+	}
+	var records [][]byte
+	dirBlocks, err := unloadfile.CreateUnload(unloadMembers, unloadfile.UnloadParams{Recfm: "FB", Lrecl: 80, Blksize: 800, Encoding: DefaultEncoding},
+		func(r []byte) error {
+			records = append(records, bytes.Clone(r))
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patch != nil {
+		patch(records)
+	}
+
+	params := xmitfile.NewXmitParams()
+	params.SourceNodeName = "NODE"
+	params.SourceUserId = "USER"
+	params.NumFiles = 1
+	params.XmitFiles = append(params.XmitFiles,
+		xmitfile.XmitFileParams{FileNumber: 1, SourceDSName: "USER.TEST.PDS", SourceDsorg: "PO", SourceRecfm: recfm,
+			SourceLrecl: 80, SourceBlksize: 800, DirBlocks: dirBlocks, UtilPgmName: "IEBCOPY"},
+		xmitfile.XmitFileParams{FileNumber: 1, SourceDsorg: "PS", SourceRecfmHw: 0x4802, SourceLrecl: 32756, SourceBlksize: 3120,
+			UtilPgmName: "INMCOPY"})
+	var xmit bytes.Buffer
+	err = xmitfile.WriteXMITFile(&xmit, params, func(_ int, writeRecord func([]byte) error) error {
+		for _, r := range records {
+			if err := writeRecord(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, DefaultEncoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return xmit.Bytes()
+}
+
+// dirEntry returns the TTR and C byte of the directory entry of a member in
+// the records of an unload. The entries are the last occurrence of the
+// names, after the keys of the directory blocks.
+func dirEntry(t *testing.T, records [][]byte, name string) []byte {
+	t.Helper()
+	key, _ := enc.EncodeString(fmt.Sprintf("%-8s", name), DefaultEncoding)
+	for _, r := range records {
+		if i := bytes.LastIndex(r, key); i >= 0 {
+			return r[i+8 : i+12]
+		}
+	}
+	t.Fatalf("no directory entry for %s", name)
+	return nil
+}
+
+// withAliases returns a patch for buildXMIT that turns members into
+// aliases. aliases maps alias names to the member they point to.
+func withAliases(t *testing.T, aliases map[string]string) func([][]byte) {
+	return func(records [][]byte) {
+		for alias, member := range aliases {
+			entry := dirEntry(t, records, alias)
+			copy(entry, dirEntry(t, records, member)[:3])
+			entry[3] |= 0x80
+		}
+	}
+}
+
+// TestConcurrentOpen reads the sample files from several goroutines at once,
+// each with its own encoding, and checks they all get the same contents.
+// Run it with -race to check the encoding tables are shared safely.
+func TestConcurrentOpen(t *testing.T) {
+	log.SetOutput(io.Discard)
+	for _, name := range []string{"jgpjcl.xmit", "jgppds.xmit"} {
+		want, err := Open(sample(name), Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for i := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				opts := Options{}
+				if i%2 == 1 {
+					opts.Encoding = "IBM-037"
+				}
+				got, err := Open(sample(name), opts)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if len(got.Files) != len(want.Files) {
+					t.Errorf("%s: %d files, want %d", name, len(got.Files), len(want.Files))
+					return
+				}
+				for j, f := range got.Files {
+					if len(f.Members) != len(want.Files[j].Members) {
+						t.Errorf("%s file %d: %d members, want %d", name, f.Number, len(f.Members), len(want.Files[j].Members))
+						continue
+					}
+					for k, m := range f.Members {
+						if !equalRecords(m.Records, want.Files[j].Members[k].Records) {
+							t.Errorf("%s: member %s differs", name, m.Name)
+						}
+					}
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func equalRecords(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// TestParamsJSON checks the JSON keys of the dataset attributes, which are
+// part of the output of the list and extract modes
+func TestParamsJSON(t *testing.T) {
+	archive, err := Open(sample("jgppds.xmit"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	marshalled, err := json.Marshal(archive.Files[0].Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys map[string]any
+	if err := json.Unmarshal(marshalled, &keys); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]any{"dsname": "JGUILLA.JGP.PLI", "dsorg": "PO", "recfm": "FB", "ddname": "", "dstype": ""} {
+		if got, found := keys[key]; !found {
+			t.Errorf("key %q missing from %s", key, marshalled)
+		} else if want != "" && got != want {
+			t.Errorf("%s is %v, want %v", key, got, want)
+		}
+	}
+}
+
+// TestMemberSelection checks that Read and the FS select the same member and
+// alias names
+func TestMemberSelection(t *testing.T) {
+	log.SetOutput(io.Discard)
+	data := buildXMIT(t, "FB", []string{"ABC", "DEF", "XYZ"}, withAliases(t, map[string]string{"XYZ": "ABC"}))
+	for _, tc := range []struct {
+		name             string
+		include, exclude []string
+		want             []string // Member names, with their aliases after a colon
+	}{
+		{"all", nil, nil, []string{"ABC:XYZ", "DEF"}},
+		{"exclude member", nil, []string{"ABC"}, []string{"DEF", "XYZ"}},
+		{"exclude alias", nil, []string{"XYZ"}, []string{"ABC", "DEF"}},
+		{"include alias", []string{"X*"}, nil, []string{"XYZ"}},
+		{"include member", []string{"A*", "D*"}, nil, []string{"ABC", "DEF"}},
+		{"exclude both", nil, []string{"ABC", "XYZ"}, []string{"DEF"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := Options{Include: tc.include, Exclude: tc.exclude}
+			archive, err := Read(bytes.NewReader(data), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range archive.Files[0].Members {
+				got = append(got, strings.Join(append([]string{m.Name}, m.Aliases...), ":"))
+				if text, err := m.Text(); err != nil || !strings.Contains(string(text), "OF MEMBER ABC") && !strings.Contains(string(text), "OF MEMBER DEF") {
+					t.Errorf("member %s: wrong data %q, %v", m.Name, text, err)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Read selected %v, want %v", got, tc.want)
+			}
+
+			fsys, err := NewFS(bytes.NewReader(data), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries, err := fs.ReadDir(fsys, "USER.TEST.PDS")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = got[:0]
+			for _, de := range entries {
+				info, err := de.Info()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hdr := info.Sys().(*Header); hdr.Member == de.Name() {
+					got = append(got, strings.Join(append([]string{hdr.Member}, hdr.Aliases...), ":"))
+				}
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("FS selected %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestEmptyRecfm checks that a PDS without RECFM is reported as unsupported
+// instead of making the deblocking panic
+func TestEmptyRecfm(t *testing.T) {
+	log.SetOutput(io.Discard)
+	data := buildXMIT(t, "", []string{"ABC"}, nil)
+	if _, err := Read(bytes.NewReader(data), Options{}); !errors.Is(err, ErrUnsupportedRecfm) {
+		t.Errorf("Read returned %v, want %v", err, ErrUnsupportedRecfm)
+	}
+	fsys, err := NewFS(bytes.NewReader(data), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadFile(fsys, "USER.TEST.PDS/ABC"); !errors.Is(err, ErrUnsupportedRecfm) {
+		t.Errorf("ReadFile returned %v, want %v", err, ErrUnsupportedRecfm)
+	}
+}
+
+// TestErrorKinds checks the kind of the errors returned for every problem
+// in the input
+func TestErrorKinds(t *testing.T) {
+	log.SetOutput(io.Discard)
+	sampleData := func(name string) []byte {
+		data, err := os.ReadFile(sample(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	xmit := sampleData("jgpjcl.xmit")
+	readArchive := func(data []byte) error {
+		_, err := Read(bytes.NewReader(data), Options{})
+		return err
+	}
+	readMember := func(name string) func([]byte) error {
+		return func(data []byte) error {
+			fsys, err := NewFS(bytes.NewReader(data), Options{})
+			if err != nil {
+				return err
+			}
+			_, err = fs.ReadFile(fsys, "USER.TEST.PDS/"+name)
+			return err
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		data []byte
+		read func([]byte) error
+		kind error
+	}{
+		{"empty input", nil, readArchive, ErrNotXMIT},
+		{"short text file", []byte("hello, world\n"), readArchive, ErrNotXMIT},
+		{"text file", bytes.Repeat([]byte("this is not a XMIT file\n"), 50), readArchive, ErrNotXMIT},
+		{"IEBCOPY unload", sampleData("jgpjcl.unload"), readArchive, ErrNotXMIT},
+		{"truncated XMIT", xmit[:len(xmit)/2], readArchive, ErrTruncated},
+		{"invalid segment length", func() []byte {
+			data := bytes.Clone(xmit)
+			data[data[0]] = 0 // Length of the second segment
+			return data
+		}(), readArchive, ErrCorruptData},
+		{"VBS PDS", buildXMIT(t, "VBS", []string{"ABC"}, nil), readArchive, ErrUnsupportedRecfm},
+		{"invalid COPYR1", buildXMIT(t, "FB", []string{"ABC"}, func(records [][]byte) {
+			records[0][1] = 0 // Eyecatcher
+		}), readArchive, ErrCorruptDirectory},
+		{"dangling TTR", buildXMIT(t, "FB", []string{"ABC", "DEF"}, func(records [][]byte) {
+			copy(dirEntry(t, records, "DEF"), []byte{0x00, 0x7f, 0x01})
+		}), readMember("DEF"), ErrMissingMemberData},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.read(tc.data)
+			if !errors.Is(err, tc.kind) {
+				t.Errorf("got error %v, want %v", err, tc.kind)
+			}
+			var formatErr *FormatError
+			if !errors.As(err, &formatErr) {
+				t.Errorf("error %T is not a FormatError", err)
+			}
+		})
+	}
+}